@@ -0,0 +1,42 @@
+package artifact
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Backend 制品的存储后端，把下载链接对应的对象写入本地文件
+// Downloader 为匿名 http(s) 与 file:// 下载，私有存储桶使用 NewCOSBackend、NewS3Backend
+type Backend interface {
+	Download(rawURL, filePath string) error
+}
+
+// withSigner 复制 Downloader，请求 endpoint 所在 host 时经过 signer 签名，其他 host 仍然匿名请求
+// 避免把签名发送到镜像以外的地址
+func (d *Downloader) withSigner(endpoint *url.URL, signer func(base http.RoundTripper) http.RoundTripper) *Downloader {
+	base := d.client.Transport
+	client := *d.client
+	client.Transport = &hostTransport{
+		host:   strings.ToLower(endpoint.Host),
+		signed: signer(base),
+		base:   base,
+	}
+	signed := *d
+	signed.client = &client
+	return &signed
+}
+
+// hostTransport 按请求的 host 选择签名或匿名的 Transport
+type hostTransport struct {
+	host   string
+	signed http.RoundTripper
+	base   http.RoundTripper
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.ToLower(req.URL.Host) == t.host {
+		return t.signed.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
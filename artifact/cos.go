@@ -0,0 +1,21 @@
+package artifact
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+)
+
+// NewCOSBackend 腾讯云对象存储的私有存储桶，请求使用 SecretId/SecretKey 签名
+// endpoint 为存储桶地址，如 https://<bucket>-<appid>.cos.<region>.myqcloud.com
+func NewCOSBackend(d *Downloader, endpoint *url.URL, secretID, secretKey, sessionToken string) Backend {
+	return d.withSigner(endpoint, func(base http.RoundTripper) http.RoundTripper {
+		return &cos.AuthorizationTransport{
+			SecretID:     secretID,
+			SecretKey:    secretKey,
+			SessionToken: sessionToken,
+			Transport:    base,
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// SHA256 目前唯一支持的摘要算法
+const SHA256 = "sha256"
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Digest 带算法前缀的摘要，格式为 sha256:<hex>
+type Digest struct {
+	Algorithm string
+	Hex       string
+}
+
+// ParseDigest 解析 sha256:<hex>，兼容旧配置中不带前缀的 64 位 sha256
+// md5 不再支持，避免下载的文件被碰撞替换
+func ParseDigest(s string) (Digest, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	algorithm, value := SHA256, s
+	if i := strings.Index(s, ":"); i >= 0 {
+		algorithm, value = s[:i], s[i+1:]
+	}
+	if algorithm != SHA256 {
+		return Digest{}, fmt.Errorf("unsupported digest algorithm %q, use sha256:<hex>", algorithm)
+	}
+	if !sha256Hex.MatchString(value) {
+		if len(value) == 32 {
+			return Digest{}, fmt.Errorf("md5 digest is not supported, use sha256:<hex>")
+		}
+		return Digest{}, fmt.Errorf("bad sha256 digest %q, want 64 hex characters", value)
+	}
+	return Digest{Algorithm: algorithm, Hex: value}, nil
+}
+
+func (d Digest) String() string {
+	return d.Algorithm + ":" + d.Hex
+}
+
+// Equal 与另一个摘要字符串比较，格式不合法时视为不相等
+func (d Digest) Equal(s string) bool {
+	other, err := ParseDigest(s)
+	return err == nil && other == d
+}
+
+// FileDigest 计算文件的 sha256
+func FileDigest(path string) (Digest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Digest{}, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return Digest{}, err
+	}
+	return Digest{Algorithm: SHA256, Hex: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Verify 校验文件的摘要
+func (d Digest) Verify(path string) error {
+	actual, err := FileDigest(path)
+	if err != nil {
+		return err
+	}
+	if actual != d {
+		return fmt.Errorf("digest mismatch: want %s, got %s", d, actual)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package artifact
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sha256("hello")
+const helloSha256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func TestParseDigest(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{in: "sha256:" + helloSha256, want: "sha256:" + helloSha256},
+		{in: " SHA256:" + strings.ToUpper(helloSha256) + "\n", want: "sha256:" + helloSha256},
+		// 兼容旧配置中不带前缀的 sha256
+		{in: helloSha256, want: "sha256:" + helloSha256},
+		{in: "5d41402abc4b2a76b9719d911017c592", wantErr: "md5 digest is not supported"},
+		{in: "md5:5d41402abc4b2a76b9719d911017c592", wantErr: `unsupported digest algorithm "md5"`},
+		{in: "sha1:aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", wantErr: `unsupported digest algorithm "sha1"`},
+		{in: "sha512:" + helloSha256, wantErr: `unsupported digest algorithm "sha512"`},
+		{in: "sha256:" + helloSha256[:63], wantErr: "want 64 hex characters"},
+		{in: "sha256:" + helloSha256[:63] + "g", wantErr: "want 64 hex characters"},
+		{in: "", wantErr: "want 64 hex characters"},
+	}
+	for _, c := range cases {
+		d, err := ParseDigest(c.in)
+		if c.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("ParseDigest(%q) err = %v, want %q", c.in, err, c.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDigest(%q) err = %v", c.in, err)
+			continue
+		}
+		if d.String() != c.want {
+			t.Errorf("ParseDigest(%q) = %s, want %s", c.in, d, c.want)
+		}
+	}
+}
+
+func TestDigestEqual(t *testing.T) {
+	d, _ := ParseDigest("sha256:" + helloSha256)
+	for in, want := range map[string]bool{
+		helloSha256:                         true,
+		"SHA256:" + helloSha256:             true,
+		"sha256:" + strings.Repeat("0", 64): false,
+		"5d41402abc4b2a76b9719d911017c592":  false,
+		"":                                  false,
+	} {
+		if got := d.Equal(in); got != want {
+			t.Errorf("Equal(%q) = %t, want %t", in, got, want)
+		}
+	}
+}
+
+func TestDigestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "module.jar")
+	if err := ioutil.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := ParseDigest(helloSha256)
+	if err := d.Verify(path); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := ParseDigest(strings.Repeat("0", 64))
+	if err := other.Verify(path); err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("err = %v, want digest mismatch", err)
+	}
+	if err := d.Verify(path + ".missing"); err == nil {
+		t.Fatal("want error for missing file")
+	}
+}
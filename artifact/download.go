@@ -0,0 +1,277 @@
+package artifact
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/zlog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PartSuffix 未下载完成的文件，下次下载时从已有长度继续(Range)
+	PartSuffix       = ".part"
+	partMetaSuffix   = ".meta" // .part 对应的下载地址与服务端的版本标识
+	progressInterval = 10 * time.Second
+	minRetryBackoff  = time.Second
+)
+
+// errTooLarge 超过大小限制，不再重试
+var errTooLarge = errors.New("file too large")
+
+// Downloader 流式下载到临时文件，支持断点续传、大小限制、代理与重试
+type Downloader struct {
+	client     *http.Client
+	maxSize    int64
+	maxRetries int
+}
+
+// NewDownloader proxy 为 nil 时使用 HTTPS_PROXY/HTTP_PROXY/NO_PROXY 环境变量
+// timeout 为单次请求的超时，超时后从已下载的位置重试
+func NewDownloader(proxy *url.URL, maxSize int64, maxRetries int, timeout time.Duration) *Downloader {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	return &Downloader{
+		client:     &http.Client{Transport: transport, Timeout: timeout},
+		maxSize:    maxSize,
+		maxRetries: maxRetries,
+	}
+}
+
+// Download 下载到 filePath，权限为 0600
+// 先写入 filePath.part 并 fsync，完整后 rename，中断后再次调用从 .part 的长度继续
+// file:// 链接直接复制本地文件
+func (d *Downloader) Download(rawURL, filePath string) error {
+	if u, err := url.Parse(rawURL); err == nil && u.Scheme == "file" {
+		if err := copyFile(u.Path, filePath, d.maxSize); err != nil {
+			return fmt.Errorf("copy %s failed: %v", u.Path, err)
+		}
+		return nil
+	}
+	partPath := filePath + PartSuffix
+	metaPath := partPath + partMetaSuffix
+	start := time.Now()
+	backoff := minRetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := d.download(rawURL, partPath)
+		if err == nil {
+			break
+		}
+		if !retry || attempt >= d.maxRetries {
+			if errors.Is(err, errTooLarge) {
+				_ = os.Remove(partPath)
+				_ = os.Remove(metaPath)
+			}
+			return fmt.Errorf("download %s failed after %d attempts: %v", redactURL(rawURL), attempt+1, err)
+		}
+		zlog.Warnf(defs.DOWNLOAD, "download retry", "url:%s,attempt:%d,err:%v", redactURL(rawURL), attempt+1, err)
+		// 加入随机抖动，避免大量主机同时重试
+		time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff))))
+		backoff *= 2
+	}
+	info, err := os.Stat(partPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(partPath, filePath); err != nil {
+		return err
+	}
+	_ = os.Remove(metaPath)
+	elapsed := time.Since(start)
+	zlog.Infof(defs.DOWNLOAD, "download finished", "url:%s,size:%d,elapsed:%s,speed:%s", redactURL(rawURL), info.Size(), elapsed.Round(time.Millisecond), speed(info.Size(), elapsed))
+	return nil
+}
+
+// partMeta 已下载部分的来源，续传前确认 .part 属于同一个文件
+type partMeta struct {
+	URL       string `json:"url"`       // 不含查询参数，预签名地址每次不同
+	Validator string `json:"validator"` // 第一次响应的 ETag 或 Last-Modified，续传时作为 If-Range
+}
+
+func readPartMeta(metaPath string) partMeta {
+	var meta partMeta
+	if data, err := ioutil.ReadFile(metaPath); err == nil {
+		_ = json.Unmarshal(data, &meta)
+	}
+	return meta
+}
+
+// partURL 去掉查询参数与 fragment 的下载地址
+func partURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.RawQuery, u.Fragment = "", ""
+	return u.String()
+}
+
+// validator 强 ETag 优先，弱 ETag 不能用于 If-Range
+func validator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// download 单次请求，返回是否可以重试
+// .part 来自其他地址(如上一个版本中断的下载)时重新下载；服务端文件已变化时 If-Range 使服务端返回完整内容
+func (d *Downloader) download(rawURL, partPath string) (bool, error) {
+	file, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+	metaPath := partPath + partMetaSuffix
+	meta := readPartMeta(metaPath)
+	if offset > 0 && meta.URL != partURL(rawURL) {
+		zlog.Infof(defs.DOWNLOAD, "discard partial download", "url:%s,size:%d", redactURL(rawURL), offset)
+		if offset, err = truncate(file); err != nil {
+			return false, err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if meta.Validator != "" {
+			req.Header.Set("If-Range", meta.Validator)
+		}
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && contentRangeStart(resp.Header.Get("Content-Range")) == offset:
+		zlog.Infof(defs.DOWNLOAD, "download resume", "url:%s,offset:%d", redactURL(rawURL), offset)
+	case resp.StatusCode == http.StatusOK:
+		// 服务端不支持 Range 或文件已变化，重新下载
+		if offset, err = truncate(file); err != nil {
+			return false, err
+		}
+		data, _ := json.Marshal(partMeta{URL: partURL(rawURL), Validator: validator(resp.Header)})
+		if err := ioutil.WriteFile(metaPath, data, 0600); err != nil {
+			return false, err
+		}
+	case resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// 返回的区间不是请求的位置，或已有的 .part 与服务端文件不一致，丢弃后重试
+		_, err := truncate(file)
+		return err == nil, fmt.Errorf("unexpected status %s", resp.Status)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("download file[%s] error:%d", redactURL(rawURL), resp.StatusCode)
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+		if total > d.maxSize {
+			return false, fmt.Errorf("%w: %d bytes, limit %d", errTooLarge, total, d.maxSize)
+		}
+	}
+	progress := &progressWriter{url: redactURL(rawURL), written: offset, total: total, start: time.Now(), offset: offset, last: time.Now()}
+	// 多读一个字节用于判断是否超过限制
+	n, err := io.Copy(io.MultiWriter(file, progress), io.LimitReader(resp.Body, d.maxSize-offset+1))
+	if err != nil {
+		// 已写入的部分保留，重试时继续
+		_ = file.Sync()
+		return true, err
+	}
+	if offset+n > d.maxSize {
+		return false, fmt.Errorf("%w: more than %d bytes", errTooLarge, d.maxSize)
+	}
+	if total >= 0 && offset+n != total {
+		_ = file.Sync()
+		return true, fmt.Errorf("short body: got %d of %d bytes", offset+n, total)
+	}
+	if err := file.Sync(); err != nil {
+		return false, err
+	}
+	return false, file.Close()
+}
+
+func truncate(file *os.File) (int64, error) {
+	if err := file.Truncate(0); err != nil {
+		return 0, err
+	}
+	return file.Seek(0, io.SeekStart)
+}
+
+// contentRangeStart 解析 Content-Range: bytes <start>-<end>/<size>
+func contentRangeStart(value string) int64 {
+	value = strings.TrimPrefix(value, "bytes ")
+	i := strings.Index(value, "-")
+	if i < 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(value[:i], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
+// progressWriter 定时输出下载进度与速度
+type progressWriter struct {
+	url     string
+	written int64
+	total   int64
+	offset  int64
+	start   time.Time
+	last    time.Time
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		percent := "unknown"
+		if p.total > 0 {
+			percent = fmt.Sprintf("%.1f%%", float64(p.written)*100/float64(p.total))
+		}
+		zlog.Infof(defs.DOWNLOAD, "download progress", "url:%s,written:%d,total:%d,percent:%s,speed:%s", p.url, p.written, p.total, percent, speed(p.written-p.offset, now.Sub(p.start)))
+	}
+	return len(b), nil
+}
+
+func speed(n int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1fKB/s", float64(n)/1024/elapsed.Seconds())
+}
+
+// redactURL 日志中去掉链接的用户信息与查询参数(预签名地址中包含签名)
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.User = nil
+	u.RawQuery = ""
+	return u.String()
+}
@@ -0,0 +1,162 @@
+package artifact
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// versionServer 按路径返回内容与 ETag，使用 http.ServeContent 处理 Range 与 If-Range
+// truncateFirst 为 true 时第一次请求声明完整长度但只返回一半
+type versionServer struct {
+	mu            sync.Mutex
+	files         map[string]string // path -> content
+	etags         map[string]string // path -> ETag
+	truncateFirst bool
+	requests      []http.Header
+}
+
+func (s *versionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Header.Clone())
+	first := len(s.requests) == 1
+	content, etag := s.files[r.URL.Path], s.etags[r.URL.Path]
+	s.mu.Unlock()
+	w.Header().Set("ETag", etag)
+	if first && s.truncateFirst {
+		w.Header().Set("Content-Length", "16")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(content[:8]))
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+}
+
+func (s *versionServer) set(path, content, etag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path], s.etags[path] = content, etag
+}
+
+func newVersionServer(t *testing.T) (*versionServer, *httptest.Server) {
+	s := &versionServer{files: make(map[string]string), etags: make(map[string]string)}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+// writePart 模拟中断的下载
+func writePart(t *testing.T, filePath, content, rawURL, validator string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filePath+PartSuffix, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(partMeta{URL: rawURL, Validator: validator})
+	if err := ioutil.WriteFile(filePath+PartSuffix+partMetaSuffix, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectContent(t *testing.T, filePath, want string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Fatalf("content = %q, want %q", data, want)
+	}
+	for _, leftover := range []string{filePath + PartSuffix, filePath + PartSuffix + partMetaSuffix} {
+		if _, err := ioutil.ReadFile(leftover); err == nil {
+			t.Errorf("%s not removed", filepath.Base(leftover))
+		}
+	}
+}
+
+func TestDownloadDiscardsPartOfOtherURL(t *testing.T) {
+	s, server := newVersionServer(t)
+	s.set("/v1/module.jar", "v1-0123456789abc", `"v1"`)
+	s.set("/v2/module.jar", "v2-0123456789abc", `"v2"`)
+	filePath := filepath.Join(t.TempDir(), "module.jar.tmp")
+	// v1 下载中断后配置改为 v2，临时文件名相同
+	writePart(t, filePath, "v1-01234", server.URL+"/v1/module.jar", `"v1"`)
+
+	d := NewDownloader(nil, 1<<20, 0, 10*time.Second)
+	if err := d.Download(server.URL+"/v2/module.jar?X-Amz-Signature=abc", filePath); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, filePath, "v2-0123456789abc")
+	if got := s.requests[0].Get("Range"); got != "" {
+		t.Fatalf("Range = %q, want a full download", got)
+	}
+}
+
+func TestDownloadIfRangeChangedFile(t *testing.T) {
+	s, server := newVersionServer(t)
+	rawURL := server.URL + "/module.jar"
+	s.set("/module.jar", "new-0123456789ab", `"new"`)
+	filePath := filepath.Join(t.TempDir(), "module.jar.tmp")
+	// 同一个地址上的文件在中断后被替换
+	writePart(t, filePath, "old-0123", rawURL, `"old"`)
+
+	d := NewDownloader(nil, 1<<20, 0, 10*time.Second)
+	if err := d.Download(rawURL, filePath); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, filePath, "new-0123456789ab")
+	if got := s.requests[0].Get("If-Range"); got != `"old"` {
+		t.Fatalf("If-Range = %q, want \"old\"", got)
+	}
+}
+
+func TestDownloadResumeAcrossCalls(t *testing.T) {
+	s, server := newVersionServer(t)
+	s.truncateFirst = true
+	rawURL := server.URL + "/module.jar"
+	s.set("/module.jar", "0123456789abcdef", `"v1"`)
+	filePath := filepath.Join(t.TempDir(), "module.jar.tmp")
+
+	// 不重试，第一次下载中断后保留 .part 与来源，下一次检查时续传
+	d := NewDownloader(nil, 1<<20, 0, 10*time.Second)
+	if err := d.Download(rawURL+"?token=1", filePath); err == nil {
+		t.Fatal("want error for short body")
+	}
+	if err := d.Download(rawURL+"?token=2", filePath); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, filePath, "0123456789abcdef")
+	resume := s.requests[1]
+	if resume.Get("Range") != "bytes=8-" || resume.Get("If-Range") != `"v1"` {
+		t.Fatalf("resume Range = %q, If-Range = %q", resume.Get("Range"), resume.Get("If-Range"))
+	}
+}
+
+func TestValidator(t *testing.T) {
+	for _, c := range []struct {
+		etag, lastModified, want string
+	}{
+		{`"abc"`, "", `"abc"`},
+		{`"abc"`, "Wed, 21 Oct 2015 07:28:00 GMT", `"abc"`},
+		// 弱 ETag 不能用于 If-Range
+		{`W/"abc"`, "Wed, 21 Oct 2015 07:28:00 GMT", "Wed, 21 Oct 2015 07:28:00 GMT"},
+		{`W/"abc"`, "", ""},
+		{"", "", ""},
+	} {
+		header := http.Header{}
+		if c.etag != "" {
+			header.Set("ETag", c.etag)
+		}
+		if c.lastModified != "" {
+			header.Set("Last-Modified", c.lastModified)
+		}
+		if got := validator(header); got != c.want {
+			t.Errorf("validator(%q, %q) = %q, want %q", c.etag, c.lastModified, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package artifact
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// SignatureSuffix 分离签名与文件同名，下载地址加上该后缀
+const SignatureSuffix = ".sig"
+
+// KeysDir 固定在安装包中的签名公钥目录，每个 *.pub 文件一个公钥
+func KeysDir(installDir string) string {
+	return filepath.Join(installDir, "cfg", "keys")
+}
+
+// Verifier 使用固定的 ed25519 公钥校验分离签名，任意一个公钥验证通过即可(用于密钥轮换)
+type Verifier struct {
+	keys []ed25519.PublicKey
+}
+
+// LoadVerifier 读取 KeysDir 下的公钥，支持 PEM(openssl pkey -pubout) 与 base64 编码的 32 字节公钥
+func LoadVerifier(installDir string) (*Verifier, error) {
+	files, err := filepath.Glob(filepath.Join(KeysDir(installDir), "*.pub"))
+	if err != nil {
+		return nil, err
+	}
+	v := &Verifier{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key %s: %v", file, err)
+		}
+		v.keys = append(v.keys, key)
+	}
+	return v, nil
+}
+
+// Verify 校验文件内容的签名，没有固定公钥时拒绝
+func (v *Verifier) Verify(path string, signature []byte) error {
+	if len(v.keys) == 0 {
+		return fmt.Errorf("no public key pinned in cfg/keys")
+	}
+	sig, err := parseSignature(signature)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, key := range v.keys {
+		if ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature verification failed for %s", filepath.Base(path))
+}
+
+// parseSignature 支持 64 字节原始签名(openssl pkeyutl -sign -rawin)与 base64 编码的签名
+func parseSignature(data []byte) ([]byte, error) {
+	if len(data) == ed25519.SignatureSize {
+		return data, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("bad ed25519 signature, want %d raw or base64 bytes", ed25519.SignatureSize)
+	}
+	return sig, nil
+}
+
+func parsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("not an ed25519 public key")
+		}
+		return edKey, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("want a PEM or base64 encoded ed25519 public key")
+	}
+	return ed25519.PublicKey(raw), nil
+}
@@ -0,0 +1,130 @@
+package artifact
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func pemKey(t *testing.T, pub ed25519.PublicKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func base64Key(pub ed25519.PublicKey) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(pub) + "\n")
+}
+
+// pinKeys 把公钥写入安装目录的 cfg/keys 下
+func pinKeys(t *testing.T, keys ...[]byte) string {
+	t.Helper()
+	installDir := t.TempDir()
+	if err := os.MkdirAll(KeysDir(installDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		name := filepath.Join(KeysDir(installDir), string(rune('a'+i))+".pub")
+		if err := ioutil.WriteFile(name, key, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return installDir
+}
+
+func TestVerifierVerify(t *testing.T) {
+	pub, priv := newKey(t)
+	otherPub, otherPriv := newKey(t)
+	content := []byte("module content")
+	path := filepath.Join(t.TempDir(), "module.jar")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	rawSig := ed25519.Sign(priv, content)
+	otherSig := ed25519.Sign(otherPriv, content)
+
+	cases := []struct {
+		name      string
+		keys      [][]byte
+		signature []byte
+		wantErr   string
+	}{
+		{name: "no pinned keys", signature: rawSig, wantErr: "no public key pinned"},
+		{name: "pem key raw signature", keys: [][]byte{pemKey(t, pub)}, signature: rawSig},
+		{name: "base64 key raw signature", keys: [][]byte{base64Key(pub)}, signature: rawSig},
+		{name: "base64 signature", keys: [][]byte{pemKey(t, pub)}, signature: []byte(base64.StdEncoding.EncodeToString(rawSig) + "\n")},
+		{name: "wrong key", keys: [][]byte{pemKey(t, otherPub)}, signature: rawSig, wantErr: "signature verification failed"},
+		{name: "signed by other key", keys: [][]byte{pemKey(t, pub)}, signature: otherSig, wantErr: "signature verification failed"},
+		// 轮换期间任意一个公钥验证通过即可
+		{name: "rotated keys", keys: [][]byte{pemKey(t, otherPub), base64Key(pub)}, signature: rawSig},
+		{name: "truncated signature", keys: [][]byte{pemKey(t, pub)}, signature: rawSig[:32], wantErr: "bad ed25519 signature"},
+		{name: "bad base64 signature", keys: [][]byte{pemKey(t, pub)}, signature: []byte("not a signature"), wantErr: "bad ed25519 signature"},
+	}
+	for _, c := range cases {
+		v, err := LoadVerifier(pinKeys(t, c.keys...))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		err = v.Verify(path, c.signature)
+		if c.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestVerifierTamperedFile(t *testing.T) {
+	pub, priv := newKey(t)
+	path := filepath.Join(t.TempDir(), "module.jar")
+	if err := ioutil.WriteFile(path, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v, err := LoadVerifier(pinKeys(t, pemKey(t, pub)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(path, ed25519.Sign(priv, []byte("original"))); err == nil {
+		t.Fatal("want verification failure for tampered file")
+	}
+}
+
+func TestLoadVerifierBadKey(t *testing.T) {
+	_, priv := newKey(t)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, key := range map[string][]byte{
+		"short base64":    []byte(base64.StdEncoding.EncodeToString([]byte("short"))),
+		"not base64":      []byte("not a key"),
+		"private key pem": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+	} {
+		if _, err := LoadVerifier(pinKeys(t, key)); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
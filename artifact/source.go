@@ -0,0 +1,176 @@
+package artifact
+
+import (
+	"fmt"
+	"io"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/zlog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 制品的来源，按顺序查找
+const (
+	SOURCE_BUNDLE = "bundle" // 离线包
+	SOURCE_CACHE  = "cache"  // 多个安装共享的缓存
+	SOURCE_REMOTE = "remote" // 镜像或原始下载链接
+)
+
+// Sources 按 离线包、共享缓存、镜像(或原始链接) 的顺序获取制品，本地的文件摘要不一致时跳过
+type Sources struct {
+	backend   Backend  // 镜像或原始链接的下载
+	maxSize   int64    // 从离线包或缓存复制时的大小限制
+	mirror    *url.URL // 内网镜像，替换下载链接的 scheme 与 host
+	cacheDir  string   // 共享缓存目录，按摘要存放
+	bundleDir string   // 离线包目录，按下载链接的文件名查找
+}
+
+// NewSources mirror、cacheDir、bundleDir 为空时不使用对应的来源
+func NewSources(backend Backend, maxSize int64, mirror *url.URL, cacheDir, bundleDir string) *Sources {
+	return &Sources{
+		backend:   backend,
+		maxSize:   maxSize,
+		mirror:    mirror,
+		cacheDir:  cacheDir,
+		bundleDir: bundleDir,
+	}
+}
+
+// Rewrite 配置了镜像时下载链接改为 <mirror>/<原路径>，去掉查询参数；file:// 链接不改写
+func (s *Sources) Rewrite(rawURL string) string {
+	if s.mirror == nil {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "file" {
+		return rawURL
+	}
+	rewritten := *s.mirror
+	rewritten.Path = strings.TrimSuffix(s.mirror.Path, "/") + "/" + strings.TrimPrefix(u.Path, "/")
+	rewritten.RawPath = ""
+	return rewritten.String()
+}
+
+// Fetch 获取 rawURL 对应的制品到 filePath 并校验摘要，signed 为 true 时同时获取签名到 filePath.sig
+// 签名由调用方校验，返回实际使用的来源
+func (s *Sources) Fetch(rawURL string, want Digest, filePath string, signed bool) (string, error) {
+	if s.bundleDir != "" {
+		if u, err := url.Parse(rawURL); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+			if s.fetchLocal(SOURCE_BUNDLE, filepath.Join(s.bundleDir, path.Base(u.Path)), want, filePath, signed) {
+				return SOURCE_BUNDLE, nil
+			}
+		}
+	}
+	if s.cacheDir != "" {
+		if s.fetchLocal(SOURCE_CACHE, s.cacheFile(want), want, filePath, signed) {
+			return SOURCE_CACHE, nil
+		}
+	}
+	remoteURL := s.Rewrite(rawURL)
+	if err := s.backend.Download(remoteURL, filePath); err != nil {
+		return SOURCE_REMOTE, err
+	}
+	if err := want.Verify(filePath); err != nil {
+		_ = os.Remove(filePath)
+		return SOURCE_REMOTE, err
+	}
+	if signed {
+		if err := s.backend.Download(remoteURL+SignatureSuffix, filePath+SignatureSuffix); err != nil {
+			_ = os.Remove(filePath)
+			return SOURCE_REMOTE, fmt.Errorf("download signature: %v", err)
+		}
+	}
+	return SOURCE_REMOTE, nil
+}
+
+// fetchLocal 从离线包或缓存复制，文件不存在或摘要不一致时返回 false
+func (s *Sources) fetchLocal(source, srcPath string, want Digest, filePath string, signed bool) bool {
+	if _, err := os.Stat(srcPath); err != nil {
+		return false
+	}
+	if signed {
+		if _, err := os.Stat(srcPath + SignatureSuffix); err != nil {
+			zlog.Warnf(defs.DOWNLOAD, "artifact signature missing", "source:%s,file:%s", source, srcPath)
+			return false
+		}
+	}
+	err := copyFile(srcPath, filePath, s.maxSize)
+	if err == nil {
+		err = want.Verify(filePath)
+	}
+	if err == nil && signed {
+		err = copyFile(srcPath+SignatureSuffix, filePath+SignatureSuffix, s.maxSize)
+	}
+	if err != nil {
+		_ = os.Remove(filePath)
+		zlog.Warnf(defs.DOWNLOAD, "skip local artifact", "source:%s,file:%s,err:%v", source, srcPath, err)
+		return false
+	}
+	zlog.Infof(defs.DOWNLOAD, "use local artifact", "source:%s,file:%s,hash:%s", source, srcPath, want)
+	return true
+}
+
+// Store 校验通过的制品放入共享缓存，其他安装不再下载；缓存中已有时不覆盖
+func (s *Sources) Store(want Digest, filePath string, signed bool) {
+	if s.cacheDir == "" {
+		return
+	}
+	cacheFile := s.cacheFile(want)
+	if want.Verify(cacheFile) == nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err != nil {
+		zlog.Warnf(defs.DOWNLOAD, "store artifact to cache failed", "dir:%s,err:%v", s.cacheDir, err)
+		return
+	}
+	// 先放签名，其他安装看到制品时签名已经存在
+	if signed {
+		if err := copyFile(filePath+SignatureSuffix, cacheFile+SignatureSuffix, s.maxSize); err != nil {
+			zlog.Warnf(defs.DOWNLOAD, "store artifact to cache failed", "file:%s,err:%v", cacheFile, err)
+			return
+		}
+	}
+	if err := copyFile(filePath, cacheFile, s.maxSize); err != nil {
+		zlog.Warnf(defs.DOWNLOAD, "store artifact to cache failed", "file:%s,err:%v", cacheFile, err)
+		return
+	}
+	_ = os.Chmod(cacheFile, 0644)
+	_ = os.Chmod(cacheFile+SignatureSuffix, 0644)
+}
+
+// cacheFile 缓存按摘要存放: <cacheDir>/sha256/<hex>
+func (s *Sources) cacheFile(digest Digest) string {
+	return filepath.Join(s.cacheDir, digest.Algorithm, digest.Hex)
+}
+
+// copyFile 复制到 dst.part 并 fsync，完整后 rename，超过 maxSize 时中止
+func copyFile(src, dst string, maxSize int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	partPath := dst + PartSuffix
+	out, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(in, maxSize+1))
+	if err == nil && n > maxSize {
+		err = fmt.Errorf("%w: more than %d bytes", errTooLarge, maxSize)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(partPath)
+		return err
+	}
+	return os.Rename(partPath, dst)
+}
@@ -1,2 +1,30 @@
 #!/bin/bash
-./jrasp-daemon
\ No newline at end of file
+# 切换到脚本所在的 bin 目录
+cd $(dirname $0) || exit 1
+binDir=`pwd`
+stateFile=${binDir}/../data/upgrade.json
+startsFile=${binDir}/../data/upgrade.starts
+# 新版本连续启动的最大次数，与 update.MaxUpgradeStartAttempts 一致
+maxStarts=3
+
+# 自升级后的新版本未在期限内报告健康(如启动即崩溃)时恢复备份，由旧版本上报失败的版本
+# 启动次数由脚本计数，新版本在检查升级状态之前退出时同样计入
+if [ -f ${stateFile} ] && [ -f ${binDir}/jrasp-daemon.bak ] && grep -q '"status": "pending"' ${stateFile}; then
+  starts=$(cat ${startsFile} 2>/dev/null)
+  starts=$(( ${starts:-0} + 1 ))
+  echo ${starts} > ${startsFile}
+  deadline=$(sed -n 's/.*"deadline": *\([0-9]*\).*/\1/p' ${stateFile})
+  if [ -n "${deadline}" ] && [ $(date +%s) -gt ${deadline} ]; then
+    echo "new jrasp-daemon not healthy before deadline, restore backup"
+    mv -f ${binDir}/jrasp-daemon.bak ${binDir}/jrasp-daemon
+    rm -f ${startsFile}
+  elif [ ${starts} -gt ${maxStarts} ]; then
+    echo "new jrasp-daemon failed to start ${maxStarts} times, restore backup"
+    mv -f ${binDir}/jrasp-daemon.bak ${binDir}/jrasp-daemon
+    rm -f ${startsFile}
+  fi
+else
+  rm -f ${startsFile}
+fi
+
+exec ${binDir}/jrasp-daemon "$@"
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command 子命令
+type command struct {
+	usage string
+	run   func(args []string) int
+}
+
+// 子命令列表，key 为 "一级命令 二级命令"
+var commands = map[string]command{}
+
+func register(name, usage string, run func(args []string) int) {
+	commands[name] = command{usage: usage, run: run}
+}
+
+// IsCommand 命令行参数是否为子命令（否则以守护进程方式运行）
+func IsCommand(args []string) bool {
+	return len(args) > 0 && !strings.HasPrefix(args[0], "-")
+}
+
+// Run 执行子命令，返回进程退出码
+func Run(args []string) int {
+	if len(args) >= 2 {
+		if c, ok := commands[args[0]+" "+args[1]]; ok {
+			return c.run(args[2:])
+		}
+	}
+	if len(args) >= 1 {
+		if c, ok := commands[args[0]]; ok {
+			return c.run(args[1:])
+		}
+	}
+	printUsage()
+	return 2
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: jrasp-daemon [command]")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", name, commands[name].usage)
+	}
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/userconfig"
+	"os"
+)
+
+func init() {
+	register("config check", "[config file]", configCheck)
+}
+
+// configCheck 校验配置文件，存在错误时返回非0
+func configCheck(args []string) int {
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+	} else {
+		installDir, err := environ.GetInstallDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "get install dir: %v\n", err)
+			return 1
+		}
+		if err := userconfig.CheckSystemConfigFiles(installDir); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		path = userconfig.SystemConfigFile(installDir)
+	}
+	data, err := userconfig.ReadConfigFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	_, warnings, err := userconfig.CheckConfig(data)
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", path, w.Error())
+	}
+	if err != nil {
+		if errs, ok := err.(userconfig.ValidationError); ok {
+			for _, fe := range errs {
+				fmt.Fprintf(os.Stderr, "%s: error: %s\n", path, fe.Error())
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: error: %v\n", path, err)
+		}
+		return 1
+	}
+	fmt.Printf("%s: ok\n", path)
+	return 0
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"fmt"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/history"
+	"os"
+	"text/tabwriter"
+)
+
+func init() {
+	register("config history", "", configHistory)
+}
+
+// configHistory 输出配置中心下发的历史版本与健康结论
+func configHistory(args []string) int {
+	installDir, err := environ.GetInstallDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get install dir: %v\n", err)
+		return 1
+	}
+	h := history.NewHistory(installDir)
+	current, _ := h.Current()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tTIME\tVERDICT\tCURRENT\tREASON")
+	for _, v := range h.Versions() {
+		mark := ""
+		if v.Version == current.Version {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.Version, v.Time, v.Verdict, mark, v.Reason)
+	}
+	_ = w.Flush()
+	return 0
+}
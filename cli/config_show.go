@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/userconfig"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+func init() {
+	register("config show", "[--config file] [--set key=value ...]", configShow)
+}
+
+// configShow 输出每个配置项的值与来源
+// 没有参数时优先读取运行中 daemon 的生效配置，否则按参数现场合并
+func configShow(args []string) int {
+	installDir, err := environ.GetInstallDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get install dir: %v\n", err)
+		return 1
+	}
+	var dump map[string]userconfig.EffectiveValue
+	if len(args) == 0 {
+		if data, err := ioutil.ReadFile(userconfig.EffectiveFile(installDir)); err == nil {
+			if err := json.Unmarshal(data, &dump); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", userconfig.EffectiveFile(installDir), err)
+				return 1
+			}
+		}
+	}
+	if dump == nil {
+		loader, err := userconfig.NewLoader(installDir, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		effective, err := loader.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		dump = effective.Dump()
+	}
+
+	keys := make([]string, 0, len(dump))
+	for k := range dump {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSOURCE\tVALUE")
+	for _, k := range keys {
+		value, _ := json.Marshal(dump[k].Value)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", k, dump[k].Source, value)
+	}
+	_ = w.Flush()
+	return 0
+}
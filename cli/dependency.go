@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/inventory"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func init() {
+	register("dependency query", `"<artifactId|groupId:artifactId> [op version]"`, dependencyQuery)
+	register("dependency report", "", dependencyReport)
+}
+
+// dependencyReport 输出去重后的依赖以及使用它的进程
+func dependencyReport(args []string) int {
+	installDir, err := environ.GetInstallDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get install dir: %v\n", err)
+		return 1
+	}
+	report, err := inventory.ReadReport(installDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read dependency report: %v\n", err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DEPENDENCY\tVERSION\tPATH\tPROCESSES")
+	for _, a := range report.Artifacts {
+		processes := make([]string, 0, len(a.Processes))
+		for _, p := range a.Processes {
+			processes = append(processes, fmt.Sprintf("%d(%s)", p.Pid, p.AppId))
+		}
+		fmt.Fprintf(w, "%s:%s\t%s\t%s\t%s\n", a.Vendor, a.Product, a.Version, a.Path, strings.Join(processes, ","))
+	}
+	_ = w.Flush()
+	return 0
+}
+
+// dependencyQuery 查询加载了指定依赖的JVM，如: dependency query "log4j-core < 2.17"
+func dependencyQuery(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, `usage: jrasp-daemon dependency query "log4j-core < 2.17"`)
+		return 2
+	}
+	q, err := inventory.ParseQuery(strings.Join(args, " "))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bad query: %v\n", err)
+		return 2
+	}
+	installDir, err := environ.GetInstallDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get install dir: %v\n", err)
+		return 1
+	}
+	store := inventory.NewStore(installDir)
+	if err := store.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "load dependency store: %v\n", err)
+		return 1
+	}
+	results := store.Find(q)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "APP\tPIDS\tDEPENDENCY\tVERSION\tPATH")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%v\t%s:%s\t%s\t%s\n", r.AppId, r.Pids, r.Vendor, r.Product, r.Version, r.Path)
+	}
+	_ = w.Flush()
+	if len(results) == 0 {
+		return 1
+	}
+	return 0
+}
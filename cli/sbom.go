@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/sbom"
+	"os"
+	"sort"
+)
+
+func init() {
+	register("sbom export", "[-format cyclonedx|spdx] [host|appId|pid]", sbomExport)
+	register("sbom list", "", sbomList)
+}
+
+// sbomExport 输出主机或单个JVM的 sbom
+func sbomExport(args []string) int {
+	fs := flag.NewFlagSet("sbom export", flag.ContinueOnError)
+	format := fs.String("format", sbom.CycloneDX, "cyclonedx or spdx")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != sbom.CycloneDX && *format != sbom.SPDX {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return 2
+	}
+	target := "host"
+	if fs.NArg() > 0 {
+		target = fs.Arg(0)
+	}
+	installDir, err := environ.GetInstallDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get install dir: %v\n", err)
+		return 1
+	}
+	data, err := sbom.ReadFile(installDir, target, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	_, _ = os.Stdout.Write(data)
+	return 0
+}
+
+// sbomList 列出已生成 sbom 的应用
+func sbomList(args []string) int {
+	installDir, err := environ.GetInstallDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get install dir: %v\n", err)
+		return 1
+	}
+	index, err := sbom.ReadIndex(installDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read sbom index: %v\n", err)
+		return 1
+	}
+	appIds := make([]string, 0, len(index))
+	for appId := range index {
+		appIds = append(appIds, appId)
+	}
+	sort.Strings(appIds)
+	for _, appId := range appIds {
+		fmt.Printf("%s\t%v\n", appId, index[appId])
+	}
+	return 0
+}
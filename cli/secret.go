@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/secret"
+	"os"
+	"strings"
+)
+
+func init() {
+	register("secret encrypt", "(reads the secret from stdin)", secretEncrypt)
+}
+
+// secretEncrypt 使用主机密钥加密，输出可以直接写入配置的 enc: 值
+func secretEncrypt(args []string) int {
+	installDir, err := environ.GetInstallDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get install dir: %v\n", err)
+		return 1
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		fmt.Fprintf(os.Stderr, "empty secret: %v\n", err)
+		return 1
+	}
+	value, err := secret.Encrypt(installDir, line)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "encrypt: %v\n", err)
+		return 1
+	}
+	fmt.Println(value)
+	return 0
+}
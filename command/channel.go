@@ -0,0 +1,112 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/nacos"
+	"jrasp-daemon/source"
+	"jrasp-daemon/userconfig"
+	"net/http"
+	"time"
+)
+
+// Channel 命令通道：接收命令，上报结果
+type Channel interface {
+	Name() string
+	Start(onCommands func(data []byte) error) error
+	Report(report Report) error
+	Stop()
+}
+
+// NewChannel 按配置创建命令通道，类型为 none 时返回 nil
+// nacos 通道复用配置来源的 nacos 客户端
+func NewChannel(cfg *userconfig.Config, env *environ.Environ, configSource source.ConfigSource) (Channel, error) {
+	cc := cfg.Command
+	switch cc.Type {
+	case userconfig.SOURCE_NACOS:
+		nacosSource, ok := configSource.(*nacos.Source)
+		if !ok {
+			return nil, fmt.Errorf("nacos command channel requires nacos config source")
+		}
+		dataId := cc.DataId
+		if dataId == "" {
+			dataId = env.HostName + "-command"
+		}
+		return &nacosChannel{source: nacosSource, dataId: dataId}, nil
+	case userconfig.SOURCE_HTTP:
+		return &httpChannel{
+			source:    source.NewHttpSource(cc.URL, time.Duration(cc.PollInterval)*time.Second),
+			resultURL: cc.ResultURL,
+			client:    &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	case userconfig.SOURCE_NONE, "":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown command channel %q", cc.Type)
+}
+
+// nacosChannel 监听 dataId，结果发布到 <dataId>-result
+type nacosChannel struct {
+	source *nacos.Source
+	dataId string
+}
+
+func (c *nacosChannel) Name() string {
+	return "nacos:" + c.dataId
+}
+
+func (c *nacosChannel) Start(onCommands func(data []byte) error) error {
+	return c.source.Listen(c.dataId, onCommands)
+}
+
+func (c *nacosChannel) Report(report Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return c.source.Publish(c.dataId+"-result", string(data))
+}
+
+func (c *nacosChannel) Stop() {
+	c.source.CancelListen(c.dataId)
+}
+
+// httpChannel 轮询命令地址，结果 POST 到 resultURL
+type httpChannel struct {
+	source    *source.HttpSource
+	resultURL string
+	client    *http.Client
+}
+
+func (c *httpChannel) Name() string {
+	return c.source.Name()
+}
+
+func (c *httpChannel) Start(onCommands func(data []byte) error) error {
+	return c.source.Start(onCommands)
+}
+
+func (c *httpChannel) Report(report Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(c.resultURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (c *httpChannel) Stop() {
+	c.source.Stop()
+}
@@ -0,0 +1,154 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/zlog"
+	"sync"
+	"time"
+)
+
+// 命令类型
+const (
+	ATTACH               = "attach"               // 注入指定进程
+	DETACH               = "detach"               // 卸载指定进程的 agent
+	FLUSH                = "flush"                // 刷新指定进程的模块
+	COLLECT_DEPENDENCIES = "collect-dependencies" // 立即采集依赖
+	UPLOAD_DIAGNOSTICS   = "upload-diagnostics"   // 打包诊断信息并上传
+	SET_LOG_LEVEL        = "set-log-level"        // 修改日志级别，重启后恢复为配置值
+	EXPORT_SBOM          = "export-sbom"          // 上传最近一次生成的 sbom
+)
+
+// 执行结果
+const (
+	SUCCESS  = "success"
+	FAILED   = "failed"
+	EXPIRED  = "expired"  // 收到时已过期，未执行
+	REJECTED = "rejected" // 未知命令或缺少过期时间，未执行
+)
+
+// Batch 命令通道下发的内容
+type Batch struct {
+	Commands []Command `json:"commands"`
+}
+
+// Command 同一个 id 只执行一次，重复下发时返回第一次的结果
+type Command struct {
+	Id       string            `json:"id"`
+	Type     string            `json:"type"`
+	HostName string            `json:"hostName"` // 为空时所有主机执行，多个主机共用 http 地址时用于区分
+	Pid      int32             `json:"pid"`      // attach、detach、flush 的目标进程
+	Args     map[string]string `json:"args"`     // 命令参数
+	ExpireAt time.Time         `json:"expireAt"` // 过期时间(RFC3339)，必填
+}
+
+// Result 命令执行结果
+type Result struct {
+	Id       string    `json:"id"`
+	Type     string    `json:"type"`
+	Status   string    `json:"status"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`     // 执行时间
+	ExpireAt time.Time `json:"expireAt"` // 命令的过期时间，用于清理执行记录
+}
+
+// Report 上报到命令通道的执行结果
+type Report struct {
+	HostName string   `json:"hostName"`
+	Results  []Result `json:"results"`
+}
+
+// Handler 执行命令，返回的字符串写入结果的 message
+type Handler func(cmd Command) (string, error)
+
+// Dispatcher 接收命令通道下发的命令，执行并上报结果
+type Dispatcher struct {
+	hostName string
+	channel  Channel
+	store    *Store
+	handlers map[string]Handler
+	mu       sync.Mutex // 命令串行执行
+}
+
+func NewDispatcher(hostName string, channel Channel, store *Store) *Dispatcher {
+	return &Dispatcher{
+		hostName: hostName,
+		channel:  channel,
+		store:    store,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle 注册命令处理函数
+func (d *Dispatcher) Handle(commandType string, handler Handler) {
+	d.handlers[commandType] = handler
+}
+
+func (d *Dispatcher) Start() error {
+	if err := d.store.Load(); err != nil {
+		zlog.Warnf(defs.REMOTE_COMMAND, "load command store failed", "err:%v", err)
+	}
+	return d.channel.Start(d.onCommands)
+}
+
+func (d *Dispatcher) Stop() {
+	d.channel.Stop()
+}
+
+func (d *Dispatcher) onCommands(data []byte) error {
+	var batch Batch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return fmt.Errorf("parse commands: %v", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var results []Result
+	for _, cmd := range batch.Commands {
+		if cmd.HostName != "" && cmd.HostName != d.hostName {
+			continue
+		}
+		if cmd.Id == "" {
+			zlog.Warnf(defs.REMOTE_COMMAND, "[onCommands]", "command without id ignored,type:%s", cmd.Type)
+			continue
+		}
+		result, ok := d.store.Get(cmd.Id)
+		if !ok {
+			result = d.execute(cmd)
+			d.store.Put(result)
+		}
+		results = append(results, result)
+	}
+	if err := d.store.Save(); err != nil {
+		zlog.Warnf(defs.REMOTE_COMMAND, "save command store failed", "err:%v", err)
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	if err := d.channel.Report(Report{HostName: d.hostName, Results: results}); err != nil {
+		return fmt.Errorf("report command results: %v", err)
+	}
+	return nil
+}
+
+func (d *Dispatcher) execute(cmd Command) Result {
+	result := Result{Id: cmd.Id, Type: cmd.Type, Time: time.Now(), ExpireAt: cmd.ExpireAt}
+	handler, ok := d.handlers[cmd.Type]
+	switch {
+	case cmd.ExpireAt.IsZero():
+		result.Status, result.Message = REJECTED, "expireAt is required"
+	case !result.Time.Before(cmd.ExpireAt):
+		result.Status, result.Message = EXPIRED, "expired at "+cmd.ExpireAt.Format(time.RFC3339)
+	case !ok:
+		result.Status, result.Message = REJECTED, "unknown command type "+cmd.Type
+	default:
+		message, err := handler(cmd)
+		if err != nil {
+			result.Status, result.Message = FAILED, err.Error()
+		} else {
+			result.Status, result.Message = SUCCESS, message
+		}
+	}
+	zlog.Infof(defs.REMOTE_COMMAND, "command executed", `{"id":"%s","type":"%s","pid":%d,"status":"%s","message":%q}`, cmd.Id, cmd.Type, cmd.Pid, result.Status, result.Message)
+	return result
+}
@@ -0,0 +1,50 @@
+package command
+
+import (
+	"fmt"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/userconfig"
+	"jrasp-daemon/watch"
+	"jrasp-daemon/zlog"
+	"strconv"
+)
+
+// RegisterHandlers 注册内置命令
+func RegisterHandlers(d *Dispatcher, current *userconfig.Current, env *environ.Environ, w *watch.Watch) {
+	d.Handle(ATTACH, func(cmd Command) (string, error) {
+		if cmd.Pid <= 0 {
+			return "", fmt.Errorf("pid is required")
+		}
+		return "", w.AttachPid(cmd.Pid)
+	})
+	d.Handle(DETACH, func(cmd Command) (string, error) {
+		if cmd.Pid <= 0 {
+			return "", fmt.Errorf("pid is required")
+		}
+		return "", w.DetachPid(cmd.Pid)
+	})
+	d.Handle(FLUSH, func(cmd Command) (string, error) {
+		if cmd.Pid <= 0 {
+			return "", fmt.Errorf("pid is required")
+		}
+		return "", w.FlushPid(cmd.Pid)
+	})
+	d.Handle(COLLECT_DEPENDENCIES, func(cmd Command) (string, error) {
+		w.CollectDependencies()
+		return "", nil
+	})
+	d.Handle(UPLOAD_DIAGNOSTICS, func(cmd Command) (string, error) {
+		return uploadDiagnostics(cmd.Args["url"], current.Load(), env, w)
+	})
+	d.Handle(EXPORT_SBOM, func(cmd Command) (string, error) {
+		return exportSbom(cmd.Args, current.Load(), env)
+	})
+	d.Handle(SET_LOG_LEVEL, func(cmd Command) (string, error) {
+		level, err := strconv.Atoi(cmd.Args["level"])
+		if err != nil || level < zlog.DebugLevel || level > zlog.FatalLevel {
+			return "", fmt.Errorf("level must be an integer between %d and %d, got %q", zlog.DebugLevel, zlog.FatalLevel, cmd.Args["level"])
+		}
+		zlog.SetLevel(level)
+		return fmt.Sprintf("log level set to %d", level), nil
+	})
+}
@@ -0,0 +1,32 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/sbom"
+	"jrasp-daemon/userconfig"
+)
+
+// exportSbom 读取最近一次采集依赖时生成的 sbom，PUT 到 args.url(与诊断包使用相同的 command.uploadUrls)
+// sbom 可能有数 MB，不放在命令结果中；args.target 为 host、应用标识或 pid，args.format 为 cyclonedx 或 spdx
+func exportSbom(args map[string]string, cfg *userconfig.Config, env *environ.Environ) (string, error) {
+	if err := checkUploadURL(args["url"], cfg.Command.UploadURLs); err != nil {
+		return "", err
+	}
+	target, format := args["target"], args["format"]
+	if target == "" {
+		target = "host"
+	}
+	if format == "" {
+		format = sbom.CycloneDX
+	}
+	data, err := sbom.ReadFile(env.InstallDir, target, format)
+	if err != nil {
+		return "", err
+	}
+	if err := upload(args["url"], "application/json", bytes.NewReader(data)); err != nil {
+		return "", fmt.Errorf("upload sbom: %v", err)
+	}
+	return fmt.Sprintf("uploaded %s sbom of %s, %d bytes", format, target, len(data)), nil
+}
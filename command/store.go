@@ -0,0 +1,64 @@
+package command
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"jrasp-daemon/utils"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 命令过期后执行记录继续保留的时间，期间重复下发不会再次执行
+const retention = 7 * 24 * time.Hour
+
+// Store 已执行命令的记录，保存在 data/commands.json，重启后命令不会重复执行
+type Store struct {
+	path    string
+	results map[string]Result
+}
+
+func NewStore(installDir string) *Store {
+	return &Store{
+		path:    filepath.Join(installDir, "data", "commands.json"),
+		results: make(map[string]Result),
+	}
+}
+
+func (s *Store) Load() error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.results)
+}
+
+func (s *Store) Get(id string) (Result, bool) {
+	r, ok := s.results[id]
+	return r, ok
+}
+
+func (s *Store) Put(r Result) {
+	s.results[r.Id] = r
+}
+
+// Save 清理过期的记录后保存
+func (s *Store) Save() error {
+	deadline := time.Now().Add(-retention)
+	for id, r := range s.results {
+		if r.ExpireAt.Before(deadline) {
+			delete(s.results, id)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	data, err := utils.MarshalIndent(s.results)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(s.path, data, 0600)
+}
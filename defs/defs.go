@@ -32,4 +32,16 @@ const (
 	JAVA_PROCESS_SHUTDOWN    int = START_LOG_ID + 19 // 发现java退出
 	AGENT_SUCCESS_INIT       int = START_LOG_ID + 20 // agent 加载成功(attach成功)
 	UPDATE_MODULE_PARAMETERS int = START_LOG_ID + 21 // 更新参数成功
+	DEPENDENCY_CHANGE        int = START_LOG_ID + 22 // 依赖新增/删除/升级
+	DEPENDENCY_STORE         int = START_LOG_ID + 23 // 依赖库读写
+	VULN_FINDING             int = START_LOG_ID + 24 // 依赖命中漏洞
+	VULN_DB                  int = START_LOG_ID + 25 // 漏洞库加载
+	SBOM_EXPORT              int = START_LOG_ID + 26 // sbom 导出
+	CONFIG_RELOAD            int = START_LOG_ID + 27 // 配置热更新
+	CONFIG_OVERLAY           int = START_LOG_ID + 28 // 按应用覆盖配置
+	CONFIG_ROLLBACK          int = START_LOG_ID + 29 // 配置回滚
+	CONFIG_SOURCE            int = START_LOG_ID + 30 // 配置来源
+	REMOTE_COMMAND           int = START_LOG_ID + 31 // 远程命令
+	SELF_UPDATE              int = START_LOG_ID + 32 // 守护进程自升级
+	MODULE_ROLLOUT           int = START_LOG_ID + 33 // 模块发布到运行中的JVM
 )
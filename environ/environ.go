@@ -5,8 +5,8 @@ import (
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/mem"
+	"jrasp-daemon/artifact"
 	"jrasp-daemon/defs"
-	"jrasp-daemon/utils"
 	"net"
 	"os"
 	"path/filepath"
@@ -26,7 +26,7 @@ type Environ struct {
 	HostName    string `json:"hostName"`    // 主机/容器名称
 	Ip          string `json:"ip"`          // ipAddress
 	OsType      string `json:"osType"`      // 操作系统类型
-	ExeFileHash string `json:"exeFileHash"` // 磁盘可执行文件的md5值
+	ExeFileHash string `json:"exeFileHash"` // 磁盘可执行文件的摘要，sha256:<hex>
 
 	// 系统信息
 	TotalMem  uint64 `json:"totalMem"`  // 总内存 GB
@@ -42,16 +42,19 @@ type Environ struct {
 
 func NewEnviron() (*Environ, error) {
 	// 可执行文件路径
-	execPath, err := filepath.Abs(os.Args[0])
+	execPath, err := executable()
 	if err != nil {
 		return nil, err
 	}
 
 	// install dir
-	execDir := filepath.Dir(filepath.Dir(execPath))
+	execDir, err := GetInstallDir()
+	if err != nil {
+		return nil, err
+	}
 
-	// md5 值
-	md5Str, err := utils.GetFileHash(execPath)
+	// sha256 摘要，与配置中的 exeOssFileHash 比较
+	digest, err := artifact.FileDigest(execPath)
 	if err != nil {
 		return nil, err
 	}
@@ -75,7 +78,7 @@ func NewEnviron() (*Environ, error) {
 		Ip:                 ipAddress,
 		InstallDir:         execDir,
 		OsType:             runtime.GOOS,
-		ExeFileHash:        md5Str,
+		ExeFileHash:        digest.String(),
 		TotalMem:           memInfo.Total / GB,
 		CpuCounts:          cpuCounts,
 		FreeDisk:           FreeDisk,
@@ -87,6 +90,24 @@ func NewEnviron() (*Environ, error) {
 	return env, nil
 }
 
+// GetInstallDir 安装目录：可执行文件位于 InstallDir/bin 下
+func GetInstallDir() (string, error) {
+	execPath, err := executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(filepath.Dir(execPath)), nil
+}
+
+// executable 可执行文件的绝对路径，不依赖 os.Args[0]，通过 PATH 或软链接启动时同样准确
+func executable() (string, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(execPath)
+}
+
 func getHostname() string {
 	hostname, _ := os.Hostname()
 	return hostname
@@ -0,0 +1,291 @@
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/userconfig"
+	"jrasp-daemon/utils"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Verdict 配置版本的健康结论
+type Verdict string
+
+const (
+	PENDING Verdict = "pending" // 观察中
+	GOOD    Verdict = "good"    // 启动成功且观察期内注入正常
+	BAD     Verdict = "bad"     // 启动失败或注入失败激增，已回滚
+)
+
+const (
+	MaxStartAttempts = 3  // 观察中的版本连续异常退出后启动的最大次数，超过视为启动失败
+	maxVersions      = 20 // 保留的历史版本数量
+)
+
+// Version 配置中心下发的一个配置版本
+type Version struct {
+	Version       string  `json:"version"`
+	Sha256        string  `json:"sha256"`
+	Time          string  `json:"time"`
+	Verdict       Verdict `json:"verdict"`
+	Reason        string  `json:"reason,omitempty"`
+	StartAttempts int     `json:"startAttempts,omitempty"`
+}
+
+// RollbackEvent 回滚事件
+type RollbackEvent struct {
+	BadVersion  string `json:"badVersion"`
+	GoodVersion string `json:"goodVersion"` // 为空表示没有可用的历史版本，不再使用配置中心的配置
+	Reason      string `json:"reason"`
+}
+
+type index struct {
+	Current  string    `json:"current"`
+	Versions []Version `json:"versions"`
+}
+
+// History 配置版本历史，保存在 InstallDir/cfg/history 下
+type History struct {
+	installDir string
+	dir        string
+	mu         sync.Mutex
+	index      index
+}
+
+// Dir 历史版本目录
+func Dir(installDir string) string {
+	return filepath.Join(installDir, "cfg", "history")
+}
+
+func NewHistory(installDir string) *History {
+	h := &History{installDir: installDir, dir: Dir(installDir)}
+	if data, err := ioutil.ReadFile(h.indexFile()); err == nil {
+		_ = json.Unmarshal(data, &h.index)
+	}
+	return h
+}
+
+func (h *History) indexFile() string {
+	return filepath.Join(h.dir, "index.json")
+}
+
+func (h *History) versionFile(version string) string {
+	return filepath.Join(h.dir, version+".json")
+}
+
+// cleanExitFile 正常退出的标记，下次启动时不计入启动次数
+func cleanExitFile(installDir string) string {
+	return filepath.Join(Dir(installDir), "clean-exit")
+}
+
+// MarkCleanExit 正常退出前调用: 收到 SIGTERM/SIGINT、需要重启的配置变化以及自身升级后的退出
+// 崩溃或被强制结束时没有标记，下次启动计入观察中版本的启动次数
+func MarkCleanExit(installDir string) {
+	_ = os.MkdirAll(Dir(installDir), 0700)
+	_ = ioutil.WriteFile(cleanExitFile(installDir), nil, 0600)
+}
+
+// Record 保存新版本并设为当前版本，内容相同的版本复用原有记录
+// changed 表示当前版本是否变化(新版本或切换到其他已有版本)，重复下发当前版本时为 false
+func (h *History) Record(data []byte) (v Version, changed bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	if existing := h.findBySha(digest); existing != nil {
+		if existing.Version == h.index.Current {
+			return *existing, false, nil
+		}
+		h.index.Current = existing.Version
+		return *existing, true, h.save()
+	}
+	now := time.Now()
+	v = Version{
+		Version: now.Format("20060102150405") + "-" + digest[:8],
+		Sha256:  digest,
+		Time:    now.Format(defs.DATE_FORMAT),
+		Verdict: PENDING,
+	}
+	if err := os.MkdirAll(h.dir, 0700); err != nil {
+		return v, false, err
+	}
+	if err := utils.WriteFileAtomic(h.versionFile(v.Version), data, 0600); err != nil {
+		return v, false, err
+	}
+	h.index.Versions = append(h.index.Versions, v)
+	h.index.Current = v.Version
+	h.prune()
+	return v, true, h.save()
+}
+
+// Find 查找内容相同的版本，用于拒绝已经回滚过的配置
+func (h *History) Find(data []byte) (Version, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sum := sha256.Sum256(data)
+	if v := h.findBySha(hex.EncodeToString(sum[:])); v != nil {
+		return *v, true
+	}
+	return Version{}, false
+}
+
+// Current 当前使用的版本
+func (h *History) Current() (Version, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if v := h.find(h.index.Current); v != nil {
+		return *v, true
+	}
+	return Version{}, false
+}
+
+// Versions 全部历史版本，按时间顺序
+func (h *History) Versions() []Version {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Version(nil), h.index.Versions...)
+}
+
+// MarkGood 观察期结束，标记为可回滚的版本
+func (h *History) MarkGood(version string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v := h.find(version)
+	if v == nil || v.Verdict != PENDING {
+		return nil
+	}
+	v.Verdict = GOOD
+	v.StartAttempts = 0
+	return h.save()
+}
+
+// StartAttempt 启动时调用，当前版本仍在观察中且连续异常退出后的启动次数超过限制时回滚
+// 上次为正常退出(见 MarkCleanExit)时清零，观察期内的正常重启不会导致回滚
+func (h *History) StartAttempt() (*RollbackEvent, error) {
+	clean := os.Remove(cleanExitFile(h.installDir)) == nil
+	h.mu.Lock()
+	v := h.find(h.index.Current)
+	if v == nil || v.Verdict != PENDING {
+		h.mu.Unlock()
+		return nil, nil
+	}
+	if clean {
+		v.StartAttempts = 0
+		err := h.save()
+		h.mu.Unlock()
+		return nil, err
+	}
+	v.StartAttempts++
+	if v.StartAttempts <= MaxStartAttempts {
+		err := h.save()
+		h.mu.Unlock()
+		return nil, err
+	}
+	h.mu.Unlock()
+	return h.Rollback(fmt.Sprintf("daemon exited abnormally %d times", MaxStartAttempts))
+}
+
+// Rollback 把当前版本标记为 bad，并把最近的 good 版本写回配置中心缓存
+func (h *History) Rollback(reason string) (*RollbackEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bad := h.find(h.index.Current)
+	if bad == nil {
+		return nil, fmt.Errorf("no current config version")
+	}
+	bad.Verdict = BAD
+	bad.Reason = reason
+	event := &RollbackEvent{BadVersion: bad.Version, Reason: reason}
+
+	remoteFile := userconfig.RemoteCacheFile(h.installDir)
+	h.index.Current = ""
+	for i := len(h.index.Versions) - 1; i >= 0; i-- {
+		good := &h.index.Versions[i]
+		if good.Verdict != GOOD {
+			continue
+		}
+		data, err := ioutil.ReadFile(h.versionFile(good.Version))
+		if err != nil {
+			continue
+		}
+		if err := utils.WriteFileAtomic(remoteFile, data, 0600); err != nil {
+			return nil, err
+		}
+		h.index.Current = good.Version
+		event.GoodVersion = good.Version
+		break
+	}
+	if h.index.Current == "" {
+		// 没有可用的历史版本时只使用本地配置
+		if err := os.Remove(remoteFile); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return event, h.save()
+}
+
+// Data 版本对应的配置内容
+func (h *History) Data(version string) ([]byte, error) {
+	return ioutil.ReadFile(h.versionFile(version))
+}
+
+func (h *History) find(version string) *Version {
+	for i := range h.index.Versions {
+		if h.index.Versions[i].Version == version {
+			return &h.index.Versions[i]
+		}
+	}
+	return nil
+}
+
+func (h *History) findBySha(digest string) *Version {
+	for i := range h.index.Versions {
+		if h.index.Versions[i].Sha256 == digest {
+			return &h.index.Versions[i]
+		}
+	}
+	return nil
+}
+
+// prune 超过数量限制时删除最早的版本，当前版本与最近的 good 版本保留
+func (h *History) prune() {
+	lastGood := ""
+	for _, v := range h.index.Versions {
+		if v.Verdict == GOOD {
+			lastGood = v.Version
+		}
+	}
+	for len(h.index.Versions) > maxVersions {
+		removed := false
+		for i, v := range h.index.Versions {
+			if v.Version == h.index.Current || v.Version == lastGood {
+				continue
+			}
+			_ = os.Remove(h.versionFile(v.Version))
+			h.index.Versions = append(h.index.Versions[:i], h.index.Versions[i+1:]...)
+			removed = true
+			break
+		}
+		if !removed {
+			return
+		}
+	}
+}
+
+func (h *History) save() error {
+	if err := os.MkdirAll(h.dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(h.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(h.indexFile(), data, 0600)
+}
@@ -0,0 +1,112 @@
+package inventory
+
+import (
+	"fmt"
+	"jrasp-daemon/utils"
+	"strings"
+)
+
+// Query 依赖查询条件，如: "log4j-core < 2.17" 或 "org.apache.logging.log4j:log4j-core<=2.16.0"
+type Query struct {
+	Vendor   string // groupId，为空时不限制
+	Product  string // artifactId
+	Operator string // 比较符: < <= > >= = !=，为空时匹配全部版本
+	Version  string
+}
+
+// QueryResult 查询命中的应用与依赖
+type QueryResult struct {
+	AppId   string  `json:"appId"`
+	Pids    []int32 `json:"pids"`
+	Vendor  string  `json:"vendor"`
+	Product string  `json:"product"`
+	Version string  `json:"version"`
+	Path    string  `json:"path"`
+}
+
+// 长的比较符在前，避免 "<=" 被解析为 "<"
+var operators = []string{"<=", ">=", "!=", "==", "<", ">", "="}
+
+// ParseQuery 解析查询表达式
+func ParseQuery(expr string) (*Query, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("empty query")
+	}
+	q := &Query{}
+	name := expr
+	for _, op := range operators {
+		idx := strings.Index(expr, op)
+		if idx < 0 {
+			continue
+		}
+		name = strings.TrimSpace(expr[:idx])
+		q.Operator = op
+		if op == "==" {
+			q.Operator = "="
+		}
+		q.Version = strings.TrimSpace(expr[idx+len(op):])
+		if q.Version == "" {
+			return nil, fmt.Errorf("query %q: missing version after %q", expr, op)
+		}
+		break
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		q.Vendor = name[:i]
+		name = name[i+1:]
+	}
+	if name == "" {
+		return nil, fmt.Errorf("query %q: missing artifactId", expr)
+	}
+	q.Product = name
+	return q, nil
+}
+
+// Match 判断依赖是否满足查询条件
+func (q *Query) Match(vendor, product, version string) bool {
+	if q.Product != product {
+		return false
+	}
+	if q.Vendor != "" && q.Vendor != vendor {
+		return false
+	}
+	if q.Operator == "" {
+		return true
+	}
+	c := utils.CompareVersion(version, q.Version)
+	switch q.Operator {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	}
+	return false
+}
+
+// Find 查询加载了满足条件依赖的应用
+func (s *Store) Find(q *Query) []QueryResult {
+	var results []QueryResult
+	for _, record := range s.Records() {
+		for _, d := range record.Dependencies {
+			if q.Match(d.Vendor, d.Product, d.Version) {
+				results = append(results, QueryResult{
+					AppId:   record.AppId,
+					Pids:    record.Pids,
+					Vendor:  d.Vendor,
+					Product: d.Product,
+					Version: d.Version,
+					Path:    d.Path,
+				})
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,80 @@
+package inventory
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	cases := []struct {
+		expr    string
+		want    Query
+		wantErr string
+	}{
+		{expr: "log4j-core", want: Query{Product: "log4j-core"}},
+		{expr: "log4j-core < 2.17", want: Query{Product: "log4j-core", Operator: "<", Version: "2.17"}},
+		// 长的比较符优先，"<=" 不会被解析为 "<" 加版本 "=2.16.0"
+		{expr: "org.apache.logging.log4j:log4j-core<=2.16.0", want: Query{Vendor: "org.apache.logging.log4j", Product: "log4j-core", Operator: "<=", Version: "2.16.0"}},
+		{expr: "log4j-core >= 2.0-beta9", want: Query{Product: "log4j-core", Operator: ">=", Version: "2.0-beta9"}},
+		{expr: "log4j-core > 2.0", want: Query{Product: "log4j-core", Operator: ">", Version: "2.0"}},
+		{expr: "log4j-core == 2.14.1", want: Query{Product: "log4j-core", Operator: "=", Version: "2.14.1"}},
+		{expr: "log4j-core=2.14.1", want: Query{Product: "log4j-core", Operator: "=", Version: "2.14.1"}},
+		{expr: "log4j-core != 2.17.1", want: Query{Product: "log4j-core", Operator: "!=", Version: "2.17.1"}},
+		{expr: "  spring-core  ", want: Query{Product: "spring-core"}},
+		{expr: "", wantErr: "empty query"},
+		{expr: "log4j-core <", wantErr: "missing version"},
+		{expr: "< 2.17", wantErr: "missing artifactId"},
+		{expr: "org.apache.logging.log4j: < 2.17", wantErr: "missing artifactId"},
+	}
+	for _, c := range cases {
+		q, err := ParseQuery(c.expr)
+		if c.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("ParseQuery(%q) err = %v, want %q", c.expr, err, c.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseQuery(%q) err = %v", c.expr, err)
+			continue
+		}
+		if *q != c.want {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", c.expr, *q, c.want)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	const vendor = "org.apache.logging.log4j"
+	cases := []struct {
+		expr    string
+		vendor  string
+		product string
+		version string
+		want    bool
+	}{
+		{"log4j-core < 2.17", vendor, "log4j-core", "2.16.0", true},
+		{"log4j-core < 2.17", vendor, "log4j-core", "2.17.0-rc1", true},
+		{"log4j-core < 2.17", vendor, "log4j-core", "2.17.0", false},
+		{"log4j-core < 2.17", vendor, "log4j-core", "2.17.1", false},
+		{"log4j-core <= 2.17", vendor, "log4j-core", "2.17.0", true},
+		{"log4j-core <= 2.17", vendor, "log4j-core", "2.17.1", false},
+		{"log4j-core > 2.17", vendor, "log4j-core", "2.17.0", false},
+		{"log4j-core >= 2.17", vendor, "log4j-core", "2.17.0", true},
+		{"log4j-core = 2.17", vendor, "log4j-core", "2.17.0", true},
+		{"log4j-core != 2.17", vendor, "log4j-core", "2.17.0", false},
+		{"log4j-core", vendor, "log4j-core", "1.0", true},
+		{"log4j-core < 2.17", vendor, "log4j-api", "2.16.0", false},
+		{vendor + ":log4j-core < 2.17", vendor, "log4j-core", "2.16.0", true},
+		{"com.example:log4j-core < 2.17", vendor, "log4j-core", "2.16.0", false},
+	}
+	for _, c := range cases {
+		q, err := ParseQuery(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := q.Match(c.vendor, c.product, c.version); got != c.want {
+			t.Errorf("%q.Match(%s:%s:%s) = %t, want %t", c.expr, c.vendor, c.product, c.version, got, c.want)
+		}
+	}
+}
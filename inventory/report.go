@@ -0,0 +1,116 @@
+package inventory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/java_process"
+	"jrasp-daemon/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ProcessRef 使用依赖的进程
+type ProcessRef struct {
+	Pid   int32  `json:"pid"`
+	AppId string `json:"appId"`
+}
+
+// Artifact 按 jar包路径+hash 去重后的依赖，以及使用它的全部进程
+type Artifact struct {
+	Vendor    string       `json:"vendor"`
+	Product   string       `json:"product"`
+	Version   string       `json:"version"`
+	Path      string       `json:"path"`
+	Hash      string       `json:"hash"`
+	Source    string       `json:"source"`
+	Processes []ProcessRef `json:"processes"`
+}
+
+// Report 主机维度的依赖报告
+type Report struct {
+	HostName  string     `json:"hostName"`
+	Time      string     `json:"time"`
+	Records   int        `json:"records"` // 去重前的依赖记录数
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// BuildReport 按 路径+hash 合并多个JVM加载的同一个jar包，路径未知时按坐标合并
+func BuildReport(hostName string, deps []java_process.Dependency) *Report {
+	report := &Report{
+		HostName: hostName,
+		Time:     time.Now().Format(defs.DATE_FORMAT),
+		Records:  len(deps),
+	}
+	index := make(map[string]int)
+	for _, d := range deps {
+		key := d.Path + "|" + d.Hash
+		if d.Path == "" {
+			key = dependencyKey(d) + ":" + d.Version
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(report.Artifacts)
+			index[key] = i
+			report.Artifacts = append(report.Artifacts, Artifact{
+				Vendor:  d.Vendor,
+				Product: d.Product,
+				Version: d.Version,
+				Path:    d.Path,
+				Hash:    d.Hash,
+				Source:  d.Source,
+			})
+		}
+		artifact := &report.Artifacts[i]
+		if !containsProcess(artifact.Processes, d.Pid) {
+			artifact.Processes = append(artifact.Processes, ProcessRef{Pid: d.Pid, AppId: d.AppId})
+		}
+	}
+	sort.Slice(report.Artifacts, func(i, j int) bool {
+		a, b := report.Artifacts[i], report.Artifacts[j]
+		if a.Vendor+":"+a.Product != b.Vendor+":"+b.Product {
+			return a.Vendor+":"+a.Product < b.Vendor+":"+b.Product
+		}
+		return a.Path < b.Path
+	})
+	return report
+}
+
+func containsProcess(list []ProcessRef, pid int32) bool {
+	for _, p := range list {
+		if p.Pid == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportFile 依赖报告文件
+func ReportFile(installDir string) string {
+	return filepath.Join(installDir, "data", "dependency-report.json")
+}
+
+// WriteReport 保存依赖报告
+func WriteReport(installDir string, report *Report) error {
+	if err := os.MkdirAll(filepath.Dir(ReportFile(installDir)), 0700); err != nil {
+		return err
+	}
+	data, err := utils.MarshalIndent(report)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(ReportFile(installDir), data, 0600)
+}
+
+// ReadReport 读取最近一次的依赖报告
+func ReadReport(installDir string) (*Report, error) {
+	data, err := ioutil.ReadFile(ReportFile(installDir))
+	if err != nil {
+		return nil, err
+	}
+	var report Report
+	err = json.Unmarshal(data, &report)
+	return &report, err
+}
@@ -0,0 +1,216 @@
+package inventory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/java_process"
+	"jrasp-daemon/utils"
+	"jrasp-daemon/zlog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ChangeType 依赖变更类型
+type ChangeType string
+
+const (
+	ADDED      ChangeType = "dependency added"
+	REMOVED    ChangeType = "dependency removed"
+	UPGRADED   ChangeType = "dependency upgraded"
+	DOWNGRADED ChangeType = "dependency downgraded"
+)
+
+// ChangeEvent 依赖变更事件
+type ChangeEvent struct {
+	AppId      string     `json:"appId"`
+	Type       ChangeType `json:"type"`
+	Vendor     string     `json:"vendor"`
+	Product    string     `json:"product"`
+	OldVersion string     `json:"oldVersion,omitempty"`
+	NewVersion string     `json:"newVersion,omitempty"`
+	Path       string     `json:"path,omitempty"`
+}
+
+// AppRecord 单个应用的依赖记录，落盘保存
+type AppRecord struct {
+	AppId        string                    `json:"appId"`
+	Pids         []int32                   `json:"pids"`       // 最近一次采集时的进程
+	UpdateTime   string                    `json:"updateTime"` // 最近一次采集时间
+	Dependencies []java_process.Dependency `json:"dependencies"`
+}
+
+// Store 主机维度的依赖库，按应用标识保存在 InstallDir/data/dependency 下
+type Store struct {
+	dir     string
+	mu      sync.Mutex
+	records map[string]*AppRecord
+}
+
+func NewStore(installDir string) *Store {
+	return &Store{
+		dir:     StoreDir(installDir),
+		records: make(map[string]*AppRecord),
+	}
+}
+
+// StoreDir 依赖库目录
+func StoreDir(installDir string) string {
+	return filepath.Join(installDir, "data", "dependency")
+}
+
+// Load 从磁盘加载已有的依赖记录，无法读取或解析的文件跳过
+func (s *Store) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		// 单个文件损坏时跳过，下一次采集时重新写入
+		fileName := filepath.Join(s.dir, file.Name())
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			zlog.Warnf(defs.DEPENDENCY_STORE, "skip dependency record", "file:%s,err:%v", fileName, err)
+			continue
+		}
+		var record AppRecord
+		if err := json.Unmarshal(data, &record); err != nil || record.AppId == "" {
+			zlog.Warnf(defs.DEPENDENCY_STORE, "skip dependency record", "file:%s,err:%v", fileName, err)
+			continue
+		}
+		s.records[record.AppId] = &record
+	}
+	return nil
+}
+
+// Update 保存应用最新的依赖列表，返回与上一次采集相比的变更事件
+// 同一个应用的多个进程需要合并后一起更新
+func (s *Store) Update(appId string, pids []int32, deps []java_process.Dependency) ([]ChangeEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []ChangeEvent
+	old, ok := s.records[appId]
+	if ok {
+		events = diff(old.Dependencies, deps)
+		for i := range events {
+			events[i].AppId = appId
+		}
+	}
+	record := &AppRecord{
+		AppId:        appId,
+		Pids:         pids,
+		UpdateTime:   time.Now().Format(defs.DATE_FORMAT),
+		Dependencies: deps,
+	}
+	s.records[appId] = record
+	return events, s.save(record)
+}
+
+// Records 全部应用的依赖记录
+func (s *Store) Records() []AppRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]AppRecord, 0, len(s.records))
+	for _, r := range s.records {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].AppId < list[j].AppId
+	})
+	return list
+}
+
+func (s *Store) save(record *AppRecord) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(s.dir, utils.SafeFileName(record.AppId)+".json"), data, 0600)
+}
+
+// 依赖的唯一标识：groupId:artifactId
+func dependencyKey(d java_process.Dependency) string {
+	return d.Vendor + ":" + d.Product
+}
+
+// 比较两次采集的依赖，同一个 groupId:artifactId 的版本变化视为升级/降级
+func diff(oldDeps, newDeps []java_process.Dependency) []ChangeEvent {
+	oldMap := groupByKey(oldDeps)
+	newMap := groupByKey(newDeps)
+
+	keys := make([]string, 0, len(oldMap)+len(newMap))
+	for k := range oldMap {
+		keys = append(keys, k)
+	}
+	for k := range newMap {
+		if _, ok := oldMap[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var events []ChangeEvent
+	for _, key := range keys {
+		oldVersions := oldMap[key]
+		newVersions := newMap[key]
+		var removed, added []java_process.Dependency
+		for v, d := range oldVersions {
+			if _, ok := newVersions[v]; !ok {
+				removed = append(removed, d)
+			}
+		}
+		for v, d := range newVersions {
+			if _, ok := oldVersions[v]; !ok {
+				added = append(added, d)
+			}
+		}
+		if len(removed) == 1 && len(added) == 1 {
+			changeType := UPGRADED
+			if utils.CompareVersion(added[0].Version, removed[0].Version) < 0 {
+				changeType = DOWNGRADED
+			}
+			events = append(events, ChangeEvent{
+				Type:       changeType,
+				Vendor:     added[0].Vendor,
+				Product:    added[0].Product,
+				OldVersion: removed[0].Version,
+				NewVersion: added[0].Version,
+				Path:       added[0].Path,
+			})
+			continue
+		}
+		for _, d := range removed {
+			events = append(events, ChangeEvent{Type: REMOVED, Vendor: d.Vendor, Product: d.Product, OldVersion: d.Version, Path: d.Path})
+		}
+		for _, d := range added {
+			events = append(events, ChangeEvent{Type: ADDED, Vendor: d.Vendor, Product: d.Product, NewVersion: d.Version, Path: d.Path})
+		}
+	}
+	return events
+}
+
+func groupByKey(deps []java_process.Dependency) map[string]map[string]java_process.Dependency {
+	m := make(map[string]map[string]java_process.Dependency)
+	for _, d := range deps {
+		key := dependencyKey(d)
+		if m[key] == nil {
+			m[key] = make(map[string]java_process.Dependency)
+		}
+		m[key][d.Version] = d
+	}
+	return m
+}
@@ -7,14 +7,25 @@ import (
 	"jrasp-daemon/defs"
 	"jrasp-daemon/zlog"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
 const (
-	BASE_URL     = "http://%s:%s"
-	shutdownUrl  = "http://%s:%s/jrasp/control/shutdown"
-	loginUrl     = "http://%s:%s/jrasp/user/login"
-	softFlushUrl = "http://%s:%s/jrasp/module/flush?force=false"
+	BASE_URL      = "http://%s:%s"
+	shutdownUrl   = "http://%s:%s/jrasp/control/shutdown"
+	loginUrl      = "http://%s:%s/jrasp/user/login"
+	softFlushUrl  = "http://%s:%s/jrasp/module/flush?force=false"
+	forceFlushUrl = "http://%s:%s/jrasp/module/flush?force=true"
+	moduleListUrl = "http://%s:%s/jrasp/module/list"
+	updateUserUrl = "http://%s:%s/jrasp/user/update"
+)
+
+// 强制刷新后确认模块加载的重试
+const (
+	verifyModuleAttempts = 3
+	verifyModuleInterval = time.Second
 )
 
 type Response struct {
@@ -74,12 +85,125 @@ func (jp *JavaProcess) SoftFlush() bool {
 	return true
 }
 
+// ModuleInfo agent 已加载的模块，/jrasp/module/list 返回的 data
+type ModuleInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Loaded  bool   `json:"loaded"`
+}
+
+// FlushModules 模块文件变化后强制刷新，agent 重新加载 required-module 下的全部模块
+// 随后确认 want 中的模块已加载(版本不为空时确认是该版本)，removed 中的模块已卸载
+func (jp *JavaProcess) FlushModules(want map[string]string, removed []string) error {
+	token, err := jp.getToken()
+	if err != nil {
+		return fmt.Errorf("get http token: %v", err)
+	}
+	resp, err := HttpGet(jp.httpClient, fmt.Sprintf(forceFlushUrl, jp.ServerIp, jp.ServerPort), "", token.Data)
+	if err != nil {
+		return fmt.Errorf("send flush request: %v", err)
+	}
+	if resp.Code != 200 {
+		return fmt.Errorf("force flush: resp.Code=%d,message=%s", resp.Code, resp.Message)
+	}
+	// 刷新后模块冻结状态丢失，重新冻结覆盖配置未启用的模块
+	jp.frozenModules = nil
+	for attempt := 1; ; attempt++ {
+		err = jp.verifyModules(token.Data, want, removed)
+		if err == nil || attempt >= verifyModuleAttempts {
+			return err
+		}
+		time.Sleep(verifyModuleInterval)
+	}
+}
+
+func (jp *JavaProcess) verifyModules(token string, want map[string]string, removed []string) error {
+	resp, err := HttpGet(jp.httpClient, fmt.Sprintf(moduleListUrl, jp.ServerIp, jp.ServerPort), "", token)
+	if err != nil {
+		return fmt.Errorf("list modules: %v", err)
+	}
+	if resp.Code != 200 {
+		return fmt.Errorf("list modules: resp.Code=%d,message=%s", resp.Code, resp.Message)
+	}
+	var modules []ModuleInfo
+	if err := json.Unmarshal([]byte(resp.Data), &modules); err != nil {
+		return fmt.Errorf("parse module list: %v", err)
+	}
+	loaded := make(map[string]ModuleInfo, len(modules))
+	for _, m := range modules {
+		loaded[m.Name] = m
+	}
+	for name, version := range want {
+		m, ok := loaded[name]
+		if !ok || !m.Loaded {
+			return fmt.Errorf("module %s not loaded", name)
+		}
+		if version != "" && m.Version != version {
+			return fmt.Errorf("module %s version is %s, want %s", name, m.Version, version)
+		}
+	}
+	for _, name := range removed {
+		if m, ok := loaded[name]; ok && m.Loaded {
+			return fmt.Errorf("module %s not unloaded", name)
+		}
+	}
+	return nil
+}
+
 // 获取token
 func (jp *JavaProcess) getToken() (*Response, error) {
-	var params = fmt.Sprintf(`username=%s&password=%s`, jp.cfg.Username, jp.cfg.Password)
+	return jp.login(jp.username, jp.password)
+}
+
+func (jp *JavaProcess) login(username, password string) (*Response, error) {
+	params := url.Values{"username": {username}, "password": {password}}.Encode()
 	return HttpPost(jp.httpClient, fmt.Sprintf(loginUrl, jp.ServerIp, jp.ServerPort), params, "")
 }
 
+// UpdateCredential 密钥轮换或升级后推送配置中的凭证，agent 无需重新注入
+// 依次尝试 agent 当前使用的凭证(注入时的凭证或 token 文件中的凭证)与配置中的凭证登录
+func (jp *JavaProcess) UpdateCredential() bool {
+	cfg := jp.cfg.Load()
+	username, password := cfg.Username, cfg.Password
+	candidates := [][2]string{{jp.username, jp.password}, {username, password}}
+	var token *Response
+	for i, c := range candidates {
+		if i > 0 && c == candidates[0] {
+			continue
+		}
+		t, err := jp.login(c[0], c[1])
+		if err != nil {
+			zlog.Errorf(defs.HTTP_TOKEN, "update agent credential", "java pid:%d,login err:%v", jp.JavaPid, err)
+			return false
+		}
+		if t.Code == 200 {
+			jp.username, jp.password = c[0], c[1]
+			token = t
+			break
+		}
+	}
+	if token == nil {
+		zlog.Errorf(defs.HTTP_TOKEN, "update agent credential", "java pid:%d,login failed with all known credentials", jp.JavaPid)
+		return false
+	}
+	if jp.username == username && jp.password == password {
+		return true
+	}
+	params := url.Values{"username": {username}, "password": {password}}.Encode()
+	resp, err := HttpPost(jp.httpClient, fmt.Sprintf(updateUserUrl, jp.ServerIp, jp.ServerPort), params, token.Data)
+	if err != nil {
+		zlog.Errorf(defs.HTTP_TOKEN, "update agent credential", "java pid:%d,send request error:%v", jp.JavaPid, err)
+		return false
+	}
+	if resp.Code != 200 {
+		zlog.Errorf(defs.HTTP_TOKEN, "update agent credential", "java pid:%d,resp.Code=%d,message=%s", jp.JavaPid, resp.Code, resp.Message)
+		return false
+	}
+	jp.username, jp.password = username, password
+	zlog.Infof(defs.HTTP_TOKEN, "update agent credential", "java pid:%d,success", jp.JavaPid)
+	return true
+}
+
 // HttpGet GET 请求
 func HttpGet(httpClient *http.Client, url string, params string, token string) (*Response, error) {
 	return HttpUtil(httpClient, url, params, token, "GET")
@@ -0,0 +1,91 @@
+package java_process
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// AppInfo 从命令行解析出的应用信息
+type AppInfo struct {
+	MainClass    string `json:"mainClass"`    // 启动类
+	Jar          string `json:"jar"`          // -jar 启动的jar包
+	CatalinaBase string `json:"catalinaBase"` // tomcat 实例目录
+}
+
+// 取值参数：参数名与参数值之间用空格分隔
+var optionsWithValue = map[string]bool{
+	"-cp":                   true,
+	"-classpath":            true,
+	"--class-path":          true,
+	"-p":                    true,
+	"--module-path":         true,
+	"--add-modules":         true,
+	"--add-opens":           true,
+	"--add-exports":         true,
+	"--add-reads":           true,
+	"--patch-module":        true,
+	"--limit-modules":       true,
+	"--upgrade-module-path": true,
+}
+
+// ParseAppInfo 解析java命令行中的启动类、jar包等信息
+func ParseAppInfo(cmdLines []string) AppInfo {
+	var info AppInfo
+	for i := 1; i < len(cmdLines); i++ {
+		arg := cmdLines[i]
+		switch {
+		case arg == "-jar":
+			if i+1 < len(cmdLines) {
+				info.Jar = cmdLines[i+1]
+			}
+			return info
+		case strings.HasPrefix(arg, "-Dcatalina.base="):
+			info.CatalinaBase = strings.TrimPrefix(arg, "-Dcatalina.base=")
+		case optionsWithValue[arg]:
+			i++ // 跳过参数值
+		case strings.HasPrefix(arg, "-"):
+			// 其他jvm参数
+		default:
+			// 第一个非参数项即为启动类，之后的为应用参数
+			info.MainClass = arg
+			return info
+		}
+	}
+	return info
+}
+
+// AppId 应用标识，进程重启后保持不变，用于关联同一个应用
+// -jar 启动时使用 jar 的完整路径，不同目录下同名的 jar 不会合并为一个应用
+func (info AppInfo) AppId() string {
+	var id string
+	if info.Jar != "" {
+		id = filepath.Clean(info.Jar)
+	} else {
+		id = info.MainClass
+	}
+	if id == "" {
+		id = "unknown"
+	}
+	if info.CatalinaBase != "" {
+		id = id + "@" + info.CatalinaBase
+	}
+	return id
+}
+
+// AppId 当前java进程的应用标识
+// 容器中的进程加上容器id，多个容器中的 java -jar /app.jar 是不同的应用
+func (jp *JavaProcess) AppId() string {
+	id := ParseAppInfo(jp.CmdLines).AppId()
+	if jp.ContainerId != "" {
+		id = id + "@container:" + shortContainerId(jp.ContainerId)
+	}
+	return id
+}
+
+// shortContainerId 与 docker ps 一致的 12 位容器id
+func shortContainerId(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,43 @@
+package java_process
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// docker 容器的元数据目录
+var DockerContainersDir = "/var/lib/docker/containers"
+
+// cgroup 路径中的容器id: /docker/<id>、/kubepods/.../docker-<id>.scope、/cri-containerd-<id>.scope
+var cgroupContainerId = regexp.MustCompile(`([0-9a-f]{64})(?:\.scope)?\s*$`)
+
+// ContainerId 从 /proc/<pid>/cgroup 的内容中解析容器id，不在容器中时为空
+func ContainerId(cgroup string) string {
+	for _, line := range strings.Split(cgroup, "\n") {
+		if m := cgroupContainerId.FindStringSubmatch(line); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// dockerLabels 读取 docker 容器的标签，kubernetes 的 pod 信息也在其中(io.kubernetes.*)
+// 其他容器运行时没有本地元数据文件，返回空
+func dockerLabels(containerId string) map[string]string {
+	data, err := ioutil.ReadFile(filepath.Join(DockerContainersDir, containerId, "config.v2.json"))
+	if err != nil {
+		return nil
+	}
+	var config struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil
+	}
+	return config.Config.Labels
+}
@@ -10,11 +10,13 @@ import (
 const DEPENDENCY_URL = "http://%s:%s/jrasp/dependency/get"
 
 type Dependency struct {
-	//Pid          int32             `json:"pid"`       // 进程pid信息
+	Pid     int32  `json:"pid"`     // 进程pid信息
+	AppId   string `json:"appId"`   // 应用标识
 	Product string `json:"product"` // jar包的artifactId
 	Version string `json:"version"` // jar包的版本version
 	Vendor  string `json:"vendor"`  // jar包的groupId
 	Path    string `json:"path"`    // jar包的路径
+	Hash    string `json:"hash"`    // jar包的sha256
 	Source  string `json:"source"`  // jar包的引入方式
 }
 
@@ -43,7 +45,17 @@ func (jp *JavaProcess) GetDependency() ([]Dependency, bool) {
 		return list, false
 	}
 	if err := json.Unmarshal([]byte(resp.Data), &list); err == nil {
+		jp.attribute(list)
 		return list, true
 	}
 	return list, false
 }
+
+// attribute 依赖关联到进程与应用
+func (jp *JavaProcess) attribute(list []Dependency) {
+	appId := jp.AppId()
+	for i := range list {
+		list[i].Pid = jp.JavaPid
+		list[i].AppId = appId
+	}
+}
@@ -0,0 +1,267 @@
+package java_process
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SOURCE_DISK 从磁盘上的jar包解析得到的依赖
+const SOURCE_DISK = "disk"
+
+const (
+	maxNestedJarSize = 64 << 20 // 嵌套jar包读入内存的最大值
+	maxNestedDepth   = 2        // 嵌套jar包的最大层数
+	maxScanJars      = 5000     // 单个进程最多扫描的jar包数量
+)
+
+// 嵌套jar包所在目录：spring boot 的 BOOT-INF/lib 与 war 包的 WEB-INF/lib
+var nestedLibDirs = []string{"BOOT-INF/lib/", "WEB-INF/lib/"}
+
+// 从文件名中解析版本号，如 log4j-core-2.17.1.jar
+var jarNameVersion = regexp.MustCompile(`^(.+?)-(\d[\w.\-]*)\.jar$`)
+
+// ScanDiskDependency 不依赖agent，根据命令行构造classpath并解析磁盘上的jar包
+func (jp *JavaProcess) ScanDiskDependency() []Dependency {
+	cwd := ""
+	if jp.process != nil {
+		cwd, _ = jp.process.Cwd()
+	}
+	scanner := &diskScanner{root: procRoot(jp.JavaPid)}
+	for _, jar := range ClassPathJars(jp.CmdLines, cwd, scanner.glob) {
+		if scanner.jars >= maxScanJars {
+			break
+		}
+		scanner.scanFile(jar)
+	}
+	jp.attribute(scanner.deps)
+	return scanner.deps
+}
+
+// ClassPathJars 从命令行中解析 -cp、-jar 以及 tomcat 的 webapps 下的jar/war包
+func ClassPathJars(cmdLines []string, cwd string, glob func(pattern string) []string) []string {
+	var jars []string
+	abs := func(p string) string {
+		if filepath.IsAbs(p) || cwd == "" {
+			return filepath.Clean(p)
+		}
+		return filepath.Join(cwd, p)
+	}
+	var catalinaBase, catalinaHome string
+	for i := 1; i < len(cmdLines); i++ {
+		arg := cmdLines[i]
+		switch {
+		case arg == "-cp" || arg == "-classpath" || arg == "--class-path":
+			if i+1 < len(cmdLines) {
+				i++
+				for _, entry := range filepath.SplitList(cmdLines[i]) {
+					entry = abs(entry)
+					if strings.HasSuffix(entry, "*") {
+						jars = append(jars, glob(entry+".jar")...)
+					} else if strings.HasSuffix(entry, ".jar") {
+						jars = append(jars, entry)
+					}
+				}
+			}
+		case arg == "-jar":
+			if i+1 < len(cmdLines) {
+				jars = append(jars, abs(cmdLines[i+1]))
+			}
+		case strings.HasPrefix(arg, "-Dcatalina.base="):
+			catalinaBase = abs(strings.TrimPrefix(arg, "-Dcatalina.base="))
+		case strings.HasPrefix(arg, "-Dcatalina.home="):
+			catalinaHome = abs(strings.TrimPrefix(arg, "-Dcatalina.home="))
+		}
+		if arg == "-jar" || (!strings.HasPrefix(arg, "-") && !optionsWithValue[cmdLines[i-1]]) {
+			break // 启动类之后是应用参数
+		}
+	}
+	if catalinaHome != "" && catalinaHome != catalinaBase {
+		jars = append(jars, glob(filepath.Join(catalinaHome, "lib", "*.jar"))...)
+	}
+	if catalinaBase != "" {
+		jars = append(jars, glob(filepath.Join(catalinaBase, "lib", "*.jar"))...)
+		jars = append(jars, glob(filepath.Join(catalinaBase, "webapps", "*", "WEB-INF", "lib", "*.jar"))...)
+		jars = append(jars, glob(filepath.Join(catalinaBase, "webapps", "*.war"))...)
+	}
+	return dedupStrings(jars)
+}
+
+type diskScanner struct {
+	root string // 容器进程的根目录前缀
+	jars int
+	deps []Dependency
+}
+
+// glob 在进程的根文件系统中匹配，返回进程视角的路径
+func (s *diskScanner) glob(pattern string) []string {
+	matches, _ := filepath.Glob(s.root + pattern)
+	for i := range matches {
+		matches[i] = strings.TrimPrefix(matches[i], s.root)
+	}
+	return matches
+}
+
+func (s *diskScanner) scanFile(jarPath string) {
+	r, err := zip.OpenReader(s.root + jarPath)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	s.scanJar(&r.Reader, jarPath, "", 0)
+}
+
+// scanJar 解析 pom.properties，没有时使用 MANIFEST.MF 与文件名，并递归解析嵌套的jar包
+// hash 为嵌套jar包内容的sha256，磁盘上的jar包为空，由使用方按路径计算
+func (s *diskScanner) scanJar(r *zip.Reader, jarPath string, hash string, depth int) {
+	s.jars++
+	var own []Dependency
+	var manifest *zip.File
+	for _, f := range r.File {
+		name := f.Name
+		switch {
+		case strings.HasPrefix(name, "META-INF/maven/") && strings.HasSuffix(name, "/pom.properties"):
+			if d, ok := readPomProperties(f, jarPath); ok {
+				own = append(own, d)
+			}
+		case name == "META-INF/MANIFEST.MF":
+			manifest = f
+		case depth < maxNestedDepth && isNestedJar(name):
+			if s.jars >= maxScanJars || f.UncompressedSize64 > maxNestedJarSize {
+				continue
+			}
+			s.scanNested(f, jarPath+"!/"+name, depth+1)
+		}
+	}
+	if len(own) == 0 {
+		if d, ok := readManifest(manifest, jarPath); ok {
+			own = append(own, d)
+		} else if d, ok := parseJarName(jarPath); ok {
+			own = append(own, d)
+		}
+	}
+	for i := range own {
+		own[i].Hash = hash
+	}
+	s.deps = append(s.deps, own...)
+}
+
+func (s *diskScanner) scanNested(f *zip.File, nestedPath string, depth int) {
+	rc, err := f.Open()
+	if err != nil {
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(rc, maxNestedJarSize))
+	_ = rc.Close()
+	if err != nil {
+		return
+	}
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(data)
+	s.scanJar(r, nestedPath, hex.EncodeToString(sum[:]), depth)
+}
+
+func isNestedJar(name string) bool {
+	if !strings.HasSuffix(name, ".jar") {
+		return false
+	}
+	for _, dir := range nestedLibDirs {
+		if strings.HasPrefix(name, dir) && !strings.Contains(strings.TrimPrefix(name, dir), "/") {
+			return true
+		}
+	}
+	return false
+}
+
+func readPomProperties(f *zip.File, jarPath string) (Dependency, bool) {
+	props, err := readProperties(f, "=")
+	if err != nil {
+		return Dependency{}, false
+	}
+	if props["artifactId"] == "" || props["version"] == "" {
+		return Dependency{}, false
+	}
+	return *NewDependency(props["artifactId"], props["version"], props["groupId"], jarPath, SOURCE_DISK), true
+}
+
+func readManifest(f *zip.File, jarPath string) (Dependency, bool) {
+	if f == nil {
+		return Dependency{}, false
+	}
+	attrs, err := readProperties(f, ":")
+	if err != nil {
+		return Dependency{}, false
+	}
+	product := attrs["Implementation-Title"]
+	version := attrs["Implementation-Version"]
+	vendor := attrs["Implementation-Vendor-Id"]
+	if product == "" || version == "" {
+		// OSGi 信息: Bundle-SymbolicName 一般为 groupId.artifactId
+		product = strings.SplitN(attrs["Bundle-SymbolicName"], ";", 2)[0]
+		version = attrs["Bundle-Version"]
+	}
+	if product == "" || version == "" {
+		return Dependency{}, false
+	}
+	return *NewDependency(strings.TrimSpace(product), version, vendor, jarPath, SOURCE_DISK), true
+}
+
+func parseJarName(jarPath string) (Dependency, bool) {
+	m := jarNameVersion.FindStringSubmatch(path.Base(filepath.ToSlash(jarPath)))
+	if m == nil {
+		return Dependency{}, false
+	}
+	return *NewDependency(m[1], m[2], "", jarPath, SOURCE_DISK), true
+}
+
+// readProperties 读取 key=value 或 key: value 格式的文件，MANIFEST.MF 的续行以空格开头
+func readProperties(f *zip.File, sep string) (map[string]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	values := make(map[string]string)
+	var lastKey string
+	scanner := bufio.NewScanner(io.LimitReader(rc, 1<<20))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if sep == ":" && strings.HasPrefix(line, " ") && lastKey != "" {
+			values[lastKey] += strings.TrimPrefix(line, " ")
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, sep, 2)
+		if len(kv) != 2 {
+			continue
+		}
+		lastKey = strings.TrimSpace(kv[0])
+		values[lastKey] = strings.TrimSpace(kv[1])
+	}
+	return values, scanner.Err()
+}
+
+func dedupStrings(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	result := list[:0]
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,62 @@
+package java_process
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// JdkInfo java 运行时信息，来自 JAVA_HOME/release 文件
+type JdkInfo struct {
+	Home    string `json:"home"`
+	Version string `json:"version"`
+	Vendor  string `json:"vendor"`
+}
+
+// ReadJdkInfo 根据 java 可执行文件路径读取 jdk 信息
+// jdk8 的 jre/bin/java 需要向上再查找一级
+// javaExe 为进程视角的路径，容器内的进程通过 root(/proc/<pid>/root) 访问，Home 仍为进程视角的路径
+func ReadJdkInfo(root, javaExe string) JdkInfo {
+	home := filepath.Dir(filepath.Dir(javaExe))
+	info := JdkInfo{Home: home}
+	for _, dir := range []string{home, filepath.Dir(home)} {
+		release, err := readRelease(filepath.Join(root, dir, "release"))
+		if err != nil {
+			continue
+		}
+		info.Home = dir
+		info.Version = release["JAVA_VERSION"]
+		info.Vendor = release["IMPLEMENTOR"]
+		break
+	}
+	return info
+}
+
+// release 文件格式: KEY="value"
+func readRelease(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		values[strings.TrimSpace(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+	}
+	return values, scanner.Err()
+}
+
+// SetJavaExe java 可执行文件路径
+func (jp *JavaProcess) SetJavaExe() {
+	exe, err := jp.process.Exe()
+	if err != nil {
+		return
+	}
+	jp.JavaExe = exe
+}
@@ -0,0 +1,103 @@
+package java_process
+
+import (
+	"fmt"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/userconfig"
+	"jrasp-daemon/zlog"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	frozenModuleUrl = "http://%s:%s/jrasp/module/frozen?ids=%s"
+	activeModuleUrl = "http://%s:%s/jrasp/module/active?ids=%s"
+)
+
+// SetContainerInfo 容器id与标签，用于覆盖配置的选择器
+func (jp *JavaProcess) SetContainerInfo() {
+	jp.ContainerId, jp.Labels = containerInfo(jp.JavaPid)
+}
+
+// Identity 匹配覆盖配置使用的应用信息
+func (jp *JavaProcess) Identity() userconfig.AppIdentity {
+	info := ParseAppInfo(jp.CmdLines)
+	return userconfig.AppIdentity{
+		MainClass: info.MainClass,
+		Jar:       info.Jar,
+		CmdLine:   strings.Join(jp.CmdLines, " "),
+		Labels:    jp.Labels,
+	}
+}
+
+// ResolveConfig 按覆盖配置计算进程的运行模式与模块配置，模块或参数变化时返回 true
+func (jp *JavaProcess) ResolveConfig() bool {
+	app := jp.cfg.Load().AppConfig(jp.Identity())
+	if app.Overlay != jp.Overlay {
+		zlog.Infof(defs.CONFIG_OVERLAY, "config overlay", `{"pid":%d,"overlay":"%s","agentMode":"%s"}`, jp.JavaPid, app.Overlay, app.AgentMode)
+	}
+	changed := !reflect.DeepEqual(app.ModuleConfigMap, jp.ModuleConfigMap)
+	jp.Overlay = app.Overlay
+	jp.AgentMode = app.AgentMode
+	jp.ModuleConfigMap = app.ModuleConfigMap
+	return changed
+}
+
+// IsDisable 进程的运行模式是否为禁用
+func (jp *JavaProcess) IsDisable() bool {
+	return jp.AgentMode == userconfig.DISABLE
+}
+
+// IsDynamicMode 进程的运行模式是否为动态注入
+func (jp *JavaProcess) IsDynamicMode() bool {
+	return jp.AgentMode == userconfig.DYNAMIC
+}
+
+// UpdateModuleSet 冻结覆盖配置未启用的模块，恢复重新启用的模块
+func (jp *JavaProcess) UpdateModuleSet() bool {
+	var frozen []string
+	for name := range jp.cfg.Load().ModuleConfigMap {
+		if _, ok := jp.ModuleConfigMap[name]; !ok {
+			frozen = append(frozen, name)
+		}
+	}
+	sort.Strings(frozen)
+	toFreeze := subtract(frozen, jp.frozenModules)
+	toActive := subtract(jp.frozenModules, frozen)
+	if len(toFreeze) == 0 && len(toActive) == 0 {
+		return true
+	}
+	token, err := jp.getToken()
+	if err != nil {
+		zlog.Errorf(defs.CONFIG_OVERLAY, "update module set", "java pid:%d,get http token err:%v", jp.JavaPid, err)
+		return false
+	}
+	requests := []struct {
+		url string
+		ids []string
+	}{{frozenModuleUrl, toFreeze}, {activeModuleUrl, toActive}}
+	for _, r := range requests {
+		if len(r.ids) == 0 {
+			continue
+		}
+		resp, err := HttpGet(jp.httpClient, fmt.Sprintf(r.url, jp.ServerIp, jp.ServerPort, strings.Join(r.ids, ",")), "", token.Data)
+		if err != nil || resp.Code != 200 {
+			zlog.Errorf(defs.CONFIG_OVERLAY, "update module set", "java pid:%d,modules:%v,resp:%v,err:%v", jp.JavaPid, r.ids, resp, err)
+			return false
+		}
+	}
+	jp.frozenModules = frozen
+	zlog.Infof(defs.CONFIG_OVERLAY, "update module set", "java pid:%d,frozen:%v,active:%v", jp.JavaPid, toFreeze, toActive)
+	return true
+}
+
+func subtract(a, b []string) []string {
+	var result []string
+	for _, s := range a {
+		if !userconfig.Contains(b, s) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -46,37 +46,58 @@ type JavaProcess struct {
 	JavaPid    int32                `json:"javaPid"`   // 进程信息
 	StartTime  string               `json:"startTime"` // 启动时间
 	CmdLines   []string             `json:"cmdLines"`  // 命令行信息
+	JavaExe    string               `json:"javaExe"`   // java 可执行文件路径
 	AgentMode  userconfig.AgentMode `json:"agentMode"` // agent 运行模式
 	ServerIp   string               `json:"serverIp"`  // 内置jetty开启的IP:端口
 	ServerPort string               `json:"serverPort"`
 
-	env     *environ.Environ   // 环境变量
-	cfg     *userconfig.Config // 配置
-	process *process.Process   // process 对象
+	ContainerId string            `json:"containerId,omitempty"` // 所在容器
+	Labels      map[string]string `json:"labels,omitempty"`      // 容器标签
+	Overlay     string            `json:"overlay,omitempty"`     // 命中的覆盖配置
+
+	env     *environ.Environ    // 环境变量
+	cfg     *userconfig.Current // 配置，热更新后读取到新的快照
+	process *process.Process    // process 对象
 
 	httpClient *http.Client
 
+	// agent 当前使用的登录凭证，密钥轮换后与配置不一致
+	username string
+	password string
+
 	InjectedStatus InjectType `json:"injectedStatus"`
 
 	NeedUpdateParameters bool // 是否需要更新参数
 
 	NeedUpdateModules bool // 是否需要刷新模块
 
-	// 模块配置信息
+	pendingModules map[string]string // 待确认加载的模块名称与版本，刷新并确认后清空
+	removedModules []string          // 待确认卸载的模块
+
+	NeedUpdateCredential bool // 是否需要推送新的登录凭证
+
+	// 远程命令卸载后不再自动注入，直到收到 attach 命令
+	DetachedByCommand bool `json:"detachedByCommand,omitempty"`
+
+	// 模块配置信息，覆盖配置生效后与全局配置不同
 	ModuleConfigMap map[string]userconfig.ModuleConfig
+
+	frozenModules []string // 覆盖配置未启用、已在agent中冻结的模块
 }
 
-func NewJavaProcess(p *process.Process, cfg *userconfig.Config, env *environ.Environ) *JavaProcess {
+func NewJavaProcess(p *process.Process, current *userconfig.Current, env *environ.Environ) *JavaProcess {
+	cfg := current.Load()
 	javaProcess := &JavaProcess{
 		JavaPid:              p.Pid,
 		process:              p,
 		env:                  env,
-		cfg:                  cfg,
+		cfg:                  current,
 		AgentMode:            cfg.AgentMode,
 		ModuleConfigMap:      cfg.ModuleConfigMap,
 		httpClient:           &http.Client{},
+		username:             cfg.Username,
+		password:             cfg.Password,
 		NeedUpdateParameters: true,
-		NeedUpdateModules:    true,
 	}
 	return javaProcess
 }
@@ -115,8 +136,10 @@ func (jp *JavaProcess) Attach() error {
 func (jp *JavaProcess) execCmd() error {
 	zlog.Infof(defs.ATTACH_DEFAULT, "[Attach]", "attach to jvm[%d] start...", jp.JavaPid)
 	// 通过attach 传递给目标jvm的参数
+	cfg := jp.cfg.Load()
+	jp.username, jp.password = cfg.Username, cfg.Password
 	agentArgs := fmt.Sprintf("raspHome=%s;serverIp=%s;serverPort=%d;namespace=%s;enableAuth=%t;username=%s;password=%s",
-		jp.env.InstallDir, serverIp, serverPort, jp.cfg.Namespace, jp.cfg.EnableAuth, jp.cfg.Username, jp.cfg.Password)
+		jp.env.InstallDir, serverIp, serverPort, cfg.Namespace, cfg.EnableAuth, jp.username, jp.password)
 
 	// jattach pid load instrument false jrasp-launcher.jar
 	cmd := exec.Command(
@@ -181,17 +204,20 @@ func (jp *JavaProcess) ReadTokenFile() bool {
 			zlog.Errorf(defs.ATTACH_READ_TOKEN, "[token file]", "read attach token file[%s],error:%v", tokenFilePath, err)
 			return false
 		}
-		fileContentStr := string(fileContent)                         // jrasp;admin;123456;0.0.0.0;61535
+		fileContentStr := string(fileContent)                         // jrasp;<username>;<password>;0.0.0.0;61535
 		fileContentStr = strings.Replace(fileContentStr, " ", "", -1) // 字符串去掉"\n"和"空格"
 		fileContentStr = strings.Replace(fileContentStr, "\n", "", -1)
 		tokenArray := strings.Split(fileContentStr, ";")
-		zlog.Debugf(defs.ATTACH_READ_TOKEN, "[token file]", "token file content:%s", fileContentStr)
+		// 文件中包含 agent 的登录凭证，日志中不输出文件内容
 		if len(tokenArray) == 5 {
+			// agent 启动时的凭证，daemon 重启或升级后用于登录并推送配置中的凭证
+			jp.username, jp.password = tokenArray[1], tokenArray[2]
 			jp.ServerIp = tokenArray[3]
 			jp.ServerPort = tokenArray[4]
+			zlog.Debugf(defs.ATTACH_READ_TOKEN, "[token file]", "token file server:%s:%s", jp.ServerIp, jp.ServerPort)
 			return true
 		} else {
-			zlog.Errorf(defs.ATTACH_READ_TOKEN, "[Attach]", "[Fix it] token file content bad,tokenFilePath:%s,fileContentStr:%s", tokenFilePath, fileContentStr)
+			zlog.Errorf(defs.ATTACH_READ_TOKEN, "[Attach]", "[Fix it] token file content bad,tokenFilePath:%s,fields:%d", tokenFilePath, len(tokenArray))
 			return false
 		}
 	} else {
@@ -243,6 +269,43 @@ func (jp *JavaProcess) UpdateParameters() bool {
 	return true
 }
 
+// ScheduleModuleFlush 模块文件变化，下一次注入定时器触发时强制刷新并确认加载或卸载
+func (jp *JavaProcess) ScheduleModuleFlush(modules map[string]string, removed []string) {
+	if jp.pendingModules == nil {
+		jp.pendingModules = make(map[string]string, len(modules))
+	}
+	for name, version := range modules {
+		jp.pendingModules[name] = version
+	}
+	for _, name := range removed {
+		delete(jp.pendingModules, name)
+		if !userconfig.Contains(jp.removedModules, name) {
+			jp.removedModules = append(jp.removedModules, name)
+		}
+	}
+	jp.NeedUpdateModules = true
+}
+
+// CancelModuleFlush 放弃待确认的刷新，模块发布多次失败或超时后调用
+func (jp *JavaProcess) CancelModuleFlush() {
+	jp.pendingModules = nil
+	jp.removedModules = nil
+	jp.NeedUpdateModules = false
+}
+
+// UpdateModules 强制刷新并确认待确认的模块已加载、已删除的模块已卸载，成功后重新推送参数
+func (jp *JavaProcess) UpdateModules() error {
+	if err := jp.FlushModules(jp.pendingModules, jp.removedModules); err != nil {
+		return err
+	}
+	zlog.Infof(defs.UPDATE_MODULE_PARAMETERS, "flush modules success", "java pid:%d,modules:%v,removed:%v", jp.JavaPid, jp.pendingModules, jp.removedModules)
+	jp.pendingModules = nil
+	jp.removedModules = nil
+	jp.NeedUpdateModules = false
+	jp.NeedUpdateParameters = true
+	return nil
+}
+
 func (jp *JavaProcess) IsInject() bool {
 	return jp.InjectedStatus == SUCCESS_INJECT || jp.InjectedStatus == FAILED_INJECT
 }
@@ -298,6 +361,8 @@ func (jp *JavaProcess) SetInjectStatus() {
 		success := jp.ReadTokenFile()
 		if success {
 			jp.MarkSuccessInjected() // 已经注入过
+			// 之前注入时的凭证未知(如升级前的默认凭证)，确认并推送配置中的凭证
+			jp.NeedUpdateCredential = true
 		} else {
 			jp.MarkFailedExitInject() // 退出失败，文件异常
 		}
@@ -5,3 +5,17 @@ import "jrasp-daemon/utils"
 func IsLoaderJar(pid int32, jarName string) bool {
 	return utils.OpenFiles(pid, jarName)
 }
+
+// ProcRoot 进程所在的根文件系统
+func ProcRoot(pid int32) string {
+	return procRoot(pid)
+}
+
+func procRoot(pid int32) string {
+	return ""
+}
+
+// 进程所在容器的id与标签
+func containerInfo(pid int32) (string, map[string]string) {
+	return "", nil
+}
@@ -27,3 +27,35 @@ func IsLoaderJar(pid int32, jarName string) bool {
 	}
 	return false
 }
+
+// ProcRoot 进程所在的根文件系统，容器内的进程需要通过 /proc/<pid>/root 访问其文件
+// 进程已退出或无权限访问时返回空，按主机路径访问
+func ProcRoot(pid int32) string {
+	return procRoot(pid)
+}
+
+func procRoot(pid int32) string {
+	root := fmt.Sprintf("/proc/%d/root", pid)
+	info, err := os.Stat(root)
+	if err != nil {
+		return ""
+	}
+	// 与 daemon 相同的根文件系统直接使用主机路径，hash 缓存在进程之间共享
+	if hostRoot, err := os.Stat("/"); err == nil && os.SameFile(info, hostRoot) {
+		return ""
+	}
+	return root
+}
+
+// 进程所在容器的id与标签
+func containerInfo(pid int32) (string, map[string]string) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", nil
+	}
+	id := ContainerId(string(data))
+	if id == "" {
+		return "", nil
+	}
+	return id, dockerLabels(id)
+}
@@ -2,9 +2,15 @@ package main
 
 import (
 	"fmt"
+	"jrasp-daemon/cli"
+	"jrasp-daemon/command"
 	"jrasp-daemon/defs"
 	"jrasp-daemon/environ"
-	"jrasp-daemon/nacos"
+	"jrasp-daemon/history"
+	"jrasp-daemon/reload"
+	"jrasp-daemon/report"
+	"jrasp-daemon/secret"
+	"jrasp-daemon/source"
 	"jrasp-daemon/update"
 	"jrasp-daemon/userconfig"
 	"jrasp-daemon/utils"
@@ -14,6 +20,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 var Sig = make(chan os.Signal, 1)
@@ -24,6 +31,11 @@ func init() {
 
 func main() {
 
+	// 子命令：查询、诊断等一次性操作
+	if cli.IsCommand(os.Args[1:]) {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
+
 	fmt.Print(defs.LOGO)
 
 	// 环境变量初始化
@@ -33,12 +45,42 @@ func main() {
 		return
 	}
 
-	// 配置初始化
-	conf, err := userconfig.InitConfig()
+	// 上一次自升级的新版本启动失败或超时时恢复备份，恢复后的旧版本上报失败的版本
+	// 在配置初始化之前检查，新版本在初始化阶段退出时同样计入启动次数
+	upgradeGuard := update.NewGuard(env)
+	upgradeFailed := upgradeGuard.Check()
+
+	// 配置初始化：默认值 < 配置文件 < --config < 环境变量 < 命令行参数 < 配置中心
+	// 默认的 agent 登录密码不再固定，首次启动时随机生成
+	if err := secret.EnsureFile(secret.AgentPasswordFile(env.InstallDir)); err != nil {
+		fmt.Printf("create agent password file error %s\n", err.Error())
+		os.Exit(1)
+	}
+	loader, err := userconfig.NewLoader(env.InstallDir, os.Args[1:])
+	if err != nil {
+		fmt.Printf("parse flags error %s\n", err.Error())
+		os.Exit(2)
+	}
+	// 配置中心下发的版本仍在观察中且连续启动失败时，回滚到上一个 good 版本
+	configHistory := history.NewHistory(env.InstallDir)
+	rollback, err := configHistory.StartAttempt()
+	if err != nil {
+		fmt.Printf("config history error %s\n", err.Error())
+	}
+	effective, err := userconfig.InitConfig(loader)
+	if _, ok := configHistory.Current(); err != nil && ok && loader.WithoutRemote().Valid() {
+		// 去掉配置中心的配置后合法，说明缓存的配置中心配置不合法
+		if rollback, err = configHistory.Rollback("invalid config: " + err.Error()); err == nil {
+			effective, err = userconfig.InitConfig(loader)
+		}
+	}
 	if err != nil {
 		fmt.Printf("userconfig init error %s\n", err.Error())
-		return
+		os.Exit(1)
 	}
+	conf := effective.Config
+	// 热更新时发布新的快照，运行中读取配置的协程使用 current
+	current := userconfig.NewCurrent(conf)
 
 	// zap日志初始化
 	zlog.InitLog(conf.LogLevel, conf.LogPath, env.HostName, env.Ip)
@@ -52,22 +94,60 @@ func main() {
 	// 环境信息打印
 	zlog.Infof(defs.ENV_VALUE, "env config value", utils.ToString(env))
 
+	if rollback != nil {
+		reload.LogRollback(rollback)
+	}
+	// 配置中心不可达时同样使用缓存的配置启动，连接恢复前心跳中标记为降级
+	if v, ok := configHistory.Current(); ok {
+		zlog.Infof(defs.CONFIG_SOURCE, "start with cached remote config", "file:%s,version:%s,verdict:%s", loader.RemoteFile, v.Version, v.Verdict)
+	}
+
 	// 配置信息打印
 	zlog.Infof(defs.CONFIG_VALUE, "user config value", utils.ToString(conf))
+	if err := userconfig.WriteEffective(env.InstallDir, effective); err != nil {
+		zlog.Warnf(defs.CONFIG_VALUE, "write effective config failed", "err:%v", err)
+	}
 
-	// 配置客户端初始化
-	nacos.NacosInit(conf, env)
-
-	// 可执行文件下载
-	ossClient := update.NewUpdateClient(conf, env)
+	// 下载最新的可执行文件、模块插件与漏洞库，之后定时检查
+	updater := update.NewScheduler(conf, env)
+	updater.Check(conf)
 
-	// 下载最新的可执行文件
-	ossClient.UpdateDaemonFile()
+	newWatch := watch.NewWatch(current, env)
 
-	// 下载模块插件
-	ossClient.DownLoadModuleFiles()
+	// 心跳、进程、依赖上报到 collector
+	reporter := report.NewClient(conf, env)
+	reporter.Start()
+	newWatch.SetReporter(reporter)
+	if upgradeFailed != nil {
+		reporter.Send(report.UPGRADE, upgradeFailed)
+	}
 
-	newWatch := watch.NewWatch(conf, env)
+	// 配置客户端初始化，配置变化时热更新
+	reloader := reload.NewReloader(current, env, newWatch, loader, configHistory, updater)
+	if v, ok := configHistory.Current(); ok && v.Verdict == history.PENDING {
+		go reloader.MonitorHealth(v)
+	}
+	configSource, err := source.New(conf, env)
+	if err != nil {
+		zlog.Warnf(defs.CONFIG_SOURCE, "create config source failed", "err:%v", err)
+	} else if configSource != nil {
+		if err := configSource.Start(reloader.Reload); err != nil {
+			zlog.Warnf(defs.CONFIG_SOURCE, "start config source failed", "source:%s,err:%v", configSource.Name(), err)
+		} else {
+			zlog.Infof(defs.CONFIG_SOURCE, "config source started", "source:%s", configSource.Name())
+		}
+		if health, ok := configSource.(source.Health); ok {
+			newWatch.SetSourceHealth(health)
+		}
+		// 注册中心的实例元数据随心跳更新
+		if registry, ok := configSource.(source.Registry); ok {
+			newWatch.OnHeartBeat(func(hb *watch.HeartBeatInfo) {
+				v, _ := configHistory.Current()
+				registry.UpdateStatus(string(current.Load().AgentMode), hb.InjectedCount(), v.Version)
+			})
+		}
+	}
+	go reloader.RefreshSecrets(time.Minute)
 
 	// jps工具
 	go newWatch.JavaProcessFilter()
@@ -78,11 +158,43 @@ func main() {
 	// 进程状态定时上报
 	go newWatch.JavaStatusTimer()
 
+	// 定时检查更新，模块文件替换后在已注入的JVM中强制刷新
+	updater.SetRollout(newWatch)
+	go updater.Run()
+
+	// 远程命令通道，命令在注入协程中执行，需要在 DoAttach 之后启动
+	var dispatcher *command.Dispatcher
+	channel, err := command.NewChannel(conf, env, configSource)
+	if err != nil {
+		zlog.Warnf(defs.REMOTE_COMMAND, "create command channel failed", "err:%v", err)
+	} else if channel != nil {
+		dispatcher = command.NewDispatcher(env.HostName, channel, command.NewStore(env.InstallDir))
+		command.RegisterHandlers(dispatcher, current, env, newWatch)
+		if err := dispatcher.Start(); err != nil {
+			zlog.Warnf(defs.REMOTE_COMMAND, "start command channel failed", "channel:%s,err:%v", channel.Name(), err)
+		} else {
+			zlog.Infof(defs.REMOTE_COMMAND, "command channel started", "channel:%s", channel.Name())
+		}
+	}
+
 	// start pprof for debug
 	go debug(conf)
 
+	// 初始化完成，新版本稳定运行后标记升级成功
+	go upgradeGuard.MonitorHealth()
+
 	// block main
 	<-Sig
+	// 正常退出，下次启动不计入观察中配置版本的启动次数
+	history.MarkCleanExit(env.InstallDir)
+	if dispatcher != nil {
+		dispatcher.Stop()
+	}
+	// 优雅退出时从注册中心注销
+	if configSource != nil {
+		configSource.Stop()
+	}
+	reporter.Stop()
 }
 
 func debug(conf *userconfig.Config) {
@@ -0,0 +1,103 @@
+package nacos
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// httpAgent 替换 sdk 默认的 http agent，sdk 每次请求新建 http.Client 且无法配置 TLS
+type httpAgent struct {
+	transport *http.Transport
+}
+
+// newHttpAgent caFile 为空时使用系统证书
+func newHttpAgent(caFile string) (*httpAgent, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &httpAgent{transport: transport}, nil
+}
+
+func (a *httpAgent) do(method, path string, header http.Header, timeoutMs uint64, params map[string]string) (*http.Response, error) {
+	values := url.Values{}
+	for key, value := range params {
+		values.Set(key, value)
+	}
+	var body string
+	switch method {
+	case http.MethodGet, http.MethodDelete:
+		if len(values) > 0 {
+			sep := "?"
+			if strings.Contains(path, "?") {
+				sep = "&"
+			}
+			path += sep + values.Encode()
+		}
+	default:
+		body = values.Encode()
+	}
+	request, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header = header
+	client := &http.Client{Transport: a.transport, Timeout: time.Millisecond * time.Duration(timeoutMs)}
+	return client.Do(request)
+}
+
+func (a *httpAgent) Get(path string, header http.Header, timeoutMs uint64, params map[string]string) (*http.Response, error) {
+	return a.do(http.MethodGet, path, header, timeoutMs, params)
+}
+
+func (a *httpAgent) Post(path string, header http.Header, timeoutMs uint64, params map[string]string) (*http.Response, error) {
+	return a.do(http.MethodPost, path, header, timeoutMs, params)
+}
+
+func (a *httpAgent) Delete(path string, header http.Header, timeoutMs uint64, params map[string]string) (*http.Response, error) {
+	return a.do(http.MethodDelete, path, header, timeoutMs, params)
+}
+
+func (a *httpAgent) Put(path string, header http.Header, timeoutMs uint64, params map[string]string) (*http.Response, error) {
+	return a.do(http.MethodPut, path, header, timeoutMs, params)
+}
+
+func (a *httpAgent) Request(method string, path string, header http.Header, timeoutMs uint64, params map[string]string) (*http.Response, error) {
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete:
+		return a.do(method, path, header, timeoutMs, params)
+	}
+	return nil, errors.New("not available method")
+}
+
+// RequestOnlyResult 请求失败或状态码不为200时返回空串
+func (a *httpAgent) RequestOnlyResult(method string, path string, header http.Header, timeoutMs uint64, params map[string]string) string {
+	response, err := a.Request(method, path, header, timeoutMs, params)
+	if err != nil {
+		return ""
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return ""
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
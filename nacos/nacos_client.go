@@ -1,98 +1,506 @@
 package nacos
 
 import (
-	"io/ioutil"
+	"fmt"
 	"jrasp-daemon/defs"
 	"jrasp-daemon/environ"
 	"jrasp-daemon/userconfig"
 	"jrasp-daemon/zlog"
-	"os"
+	"math/rand"
+	"net/http"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/nacos-group/nacos-sdk-go/clients"
+	"github.com/nacos-group/nacos-sdk-go/clients/config_client"
+	"github.com/nacos-group/nacos-sdk-go/clients/nacos_client"
+	"github.com/nacos-group/nacos-sdk-go/clients/naming_client"
 	"github.com/nacos-group/nacos-sdk-go/common/constant"
+	"github.com/nacos-group/nacos-sdk-go/common/http_agent"
 	"github.com/nacos-group/nacos-sdk-go/vo"
 )
 
-func NacosInit(cfg *userconfig.Config, env *environ.Environ) {
+const (
+	clusterName = "DEFAULT"
 
-	clientConfig := constant.ClientConfig{
+	// SERVICE_NAME 所有主机注册到同一个服务下，nacos 控制台的实例列表即为主机清单
+	SERVICE_NAME = "jrasp-daemon"
+)
+
+// 连接检测：正常时固定间隔，不可达时指数退避重连
+const (
+	checkInterval = 30 * time.Second
+	minBackoff    = time.Second
+	maxBackoff    = 5 * time.Minute
+)
+
+// Source nacos 配置来源：注册服务并监听配置
+// nacos 不可达时使用本地缓存的配置(cfg/remote.json)启动，后台重连
+type Source struct {
+	cfg    *userconfig.Config
+	env    *environ.Environ
+	dataId string
+	group  string
+
+	// Start 中设置，之后只读
+	clientConfig  constant.ClientConfig
+	serverConfigs []constant.ServerConfig
+	agent         *httpAgent
+
+	// 以下字段由 mu 保护；访问 nacos 的网络请求都不持有 mu，nacos 无响应时不阻塞 Degraded 与心跳
+	mu           sync.Mutex
+	configClient config_client.IConfigClient
+	namingClient naming_client.INamingClient
+	instance     vo.RegisterInstanceParam // 已注册的实例，元数据变化时重新注册
+	registered   bool
+	listeners    map[string]func(data []byte) error // 监听的 dataId，客户端创建后统一监听
+	listening    map[string]bool                    // 已调用 ListenConfig 的 dataId
+	degraded     bool                               // nacos 不可达或客户端未创建
+	lastErr      error                              // 最近一次连接检测的错误
+
+	refresh chan struct{} // 实例元数据变化，通知 monitor 重新注册
+	done    chan struct{}
+}
+
+func NewSource(cfg *userconfig.Config, env *environ.Environ) *Source {
+	// dataId配置值为空时，使用主机名称
+	dataId := cfg.DataId
+	if dataId == "" {
+		dataId = env.HostName
+	}
+	return &Source{
+		cfg:       cfg,
+		env:       env,
+		dataId:    dataId,
+		group:     cfg.Nacos.Group,
+		listeners: make(map[string]func(data []byte) error),
+		listening: make(map[string]bool),
+		refresh:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+func (s *Source) Name() string {
+	return "nacos:" + s.dataId
+}
+
+// Start 注册服务并监听配置，配置变化时调用 onChange
+// 只有配置错误时返回 error，nacos 不可达时标记为降级并在后台重连
+func (s *Source) Start(onChange func(data []byte) error) error {
+	cfg, env := s.cfg, s.env
+	nc := cfg.Nacos
+
+	s.clientConfig = constant.ClientConfig{
 		NamespaceId:         cfg.NamespaceId,
-		TimeoutMs:           5000,
+		TimeoutMs:           nc.TimeoutMs,
 		NotLoadCacheAtStart: true,
-		LogDir:              "/tmp/nacos/log",
-		CacheDir:            "/tmp/nacos/cache",
+		LogDir:              s.path(nc.LogDir),
+		CacheDir:            s.path(nc.CacheDir),
 		RotateTime:          "24h",
 		MaxAge:              3,
 		LogLevel:            "error",
+		Username:            nc.Username,
+		Password:            nc.Password,
+		AccessKey:           nc.AccessKey,
+		SecretKey:           nc.SecretKey,
 	}
 
-	var serverConfigs []constant.ServerConfig
-
 	for i := 0; i < len(cfg.IpAddrs); i++ {
 		serverConfig := constant.ServerConfig{
 			IpAddr:      cfg.IpAddrs[i],
-			ContextPath: "/nacos",
-			Port:        8848,
-			Scheme:      "http",
+			ContextPath: nc.ContextPath,
+			Port:        nc.Port,
+			Scheme:      nc.Scheme,
 		}
-		serverConfigs = append(serverConfigs, serverConfig)
+		s.serverConfigs = append(s.serverConfigs, serverConfig)
 	}
 
-	// 将服务注册到nacos
-	namingClient, _ := clients.NewNamingClient(
-		vo.NacosClientParam{
-			ClientConfig:  &clientConfig,
-			ServerConfigs: serverConfigs,
-		},
-	)
+	caFile := ""
+	if nc.CaFile != "" {
+		caFile = s.path(nc.CaFile)
+	}
+	agent, err := newHttpAgent(caFile)
+	if err != nil {
+		return err
+	}
+	s.agent = agent
 
-	registerStatus, err := namingClient.RegisterInstance(vo.RegisterInstanceParam{
-		Ip:          env.HostName,
-		Port:        8848,
-		ServiceName: env.HostName,
+	// daemon 没有业务端口，使用 pprof 端口区分同一个ip上的实例
+	s.instance = vo.RegisterInstanceParam{
+		Ip:          env.Ip,
+		Port:        uint64(cfg.PprofPort),
+		ServiceName: SERVICE_NAME,
 		Weight:      10,
 		Enable:      true,
 		Healthy:     true,
 		Ephemeral:   true,
-		Metadata:    map[string]string{"raspVersion": defs.JRASP_DAEMON_VERSION},
-		ClusterName: "DEFAULT",       // 默认值DEFAULT
-		GroupName:   "DEFAULT_GROUP", // 默认值DEFAULT_GROUP
-	})
-	if err != nil {
-		zlog.Warnf(defs.NACOS_INIT, "[registerStatus]", "registerStatus:%t,err:%v", registerStatus, err)
+		Metadata:    s.metadata(string(cfg.AgentMode), 0, ""),
+		ClusterName: clusterName,
+		GroupName:   s.group,
 	}
 
-	configClient, err := clients.NewConfigClient(
-		vo.NacosClientParam{
-			ClientConfig:  &clientConfig,
-			ServerConfigs: serverConfigs,
-		},
-	)
+	s.mu.Lock()
+	s.listeners[s.dataId] = func(data []byte) error {
+		// 按 dataId 的扩展名识别 json/yaml/toml，统一转换为 json
+		content, err := userconfig.ToJSON(userconfig.FormatOf(s.dataId, data), data)
+		if err != nil {
+			return fmt.Errorf("parse config: %v", err)
+		}
+		return onChange(content)
+	}
+	s.mu.Unlock()
+	s.connect(false)
+	s.mu.Lock()
+	degraded, lastErr := s.degraded, s.lastErr
+	s.mu.Unlock()
 
-	// dataId配置值为空时，使用主机名称
-	var dataId = ""
-	if cfg.DataId == "" {
-		dataId = env.HostName
+	if degraded {
+		zlog.Warnf(defs.NACOS_INIT, "[NacosInit]", "nacos unreachable, use cached config and reconnect in background,err:%v", lastErr)
+	} else {
+		zlog.Infof(defs.NACOS_INIT, "[NacosInit]", "nacos init success")
+	}
+	go s.monitor()
+	return nil
+}
+
+// Degraded nacos 不可达时返回 true 与原因
+func (s *Source) Degraded() (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.degraded {
+		return false, ""
+	}
+	return true, fmt.Sprint(s.lastErr)
+}
+
+// monitor 定时检测连接，不可达时按指数退避(加随机抖动)重连
+func (s *Source) monitor() {
+	backoff := minBackoff
+	for {
+		s.mu.Lock()
+		wasDegraded := s.degraded
+		s.mu.Unlock()
+
+		wait := checkInterval
+		if wasDegraded {
+			wait = backoff + time.Duration(rand.Int63n(int64(backoff)))
+		}
+		// 等待期间处理元数据变化，不重置检测的计时
+		timer := time.NewTimer(wait)
+	waiting:
+		for {
+			select {
+			case <-s.done:
+				timer.Stop()
+				return
+			case <-s.refresh:
+				s.reRegister()
+			case <-timer.C:
+				break waiting
+			}
+		}
+
+		// 恢复连接后重新注册，服务端可能已经删除了心跳超时的临时实例
+		s.connect(wasDegraded)
+		s.mu.Lock()
+		degraded, lastErr := s.degraded, s.lastErr
+		s.mu.Unlock()
+
+		switch {
+		case degraded && !wasDegraded:
+			zlog.Warnf(defs.NACOS_INIT, "[monitor]", "nacos unreachable, config source degraded,err:%v", lastErr)
+		case !degraded && wasDegraded:
+			zlog.Infof(defs.NACOS_INIT, "[monitor]", "nacos reconnected")
+		}
+		if degraded {
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		} else {
+			backoff = minBackoff
+		}
+	}
+}
+
+// connect 创建客户端、监听配置、注册实例，已完成的步骤不重复执行
+// 只在 Start 与 monitor 中调用；网络请求不持有 mu，完成后加锁发布结果
+func (s *Source) connect(reRegister bool) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	lastErr := s.probe()
+	s.mu.Lock()
+	configClient, namingClient, registered, instance := s.configClient, s.namingClient, s.registered, s.instance
+	s.mu.Unlock()
+
+	if configClient == nil {
+		client, err := newConfigClient(s.clientConfig, s.serverConfigs, s.agent)
+		if err != nil {
+			lastErr = fmt.Errorf("create nacos config client: %v", err)
+		} else {
+			configClient = client
+		}
+	}
+	if namingClient == nil {
+		client, err := newNamingClient(s.clientConfig, s.serverConfigs, s.agent)
+		if err != nil {
+			zlog.Warnf(defs.NACOS_INIT, "[registerStatus]", "create naming client,err:%v", err)
+		} else {
+			namingClient = client
+		}
+	}
+	if lastErr == nil && namingClient != nil && (!registered || reRegister) {
+		registered = register(namingClient, instance)
+	}
+
+	s.mu.Lock()
+	s.configClient, s.namingClient = configClient, namingClient
+	s.registered = registered
+	s.lastErr = lastErr
+	s.degraded = lastErr != nil || configClient == nil
+	s.mu.Unlock()
+	s.listenPending()
+}
+
+// reRegister 元数据变化后重新注册，不可达时由重连注册
+func (s *Source) reRegister() {
+	s.mu.Lock()
+	namingClient, degraded, instance := s.namingClient, s.degraded, s.instance
+	s.mu.Unlock()
+	if namingClient == nil || degraded {
+		return
+	}
+	registered := register(namingClient, instance)
+	s.mu.Lock()
+	s.registered = registered
+	s.mu.Unlock()
+}
+
+// probe 任意一个服务端就绪即视为可达，只读取 Start 中设置的字段，不需要持有 mu
+func (s *Source) probe() error {
+	var lastErr error
+	for _, server := range s.serverConfigs {
+		contextPath := strings.TrimSuffix("/"+strings.Trim(server.ContextPath, "/"), "/")
+		url := fmt.Sprintf("%s://%s:%d%s/v1/console/health/readiness", server.Scheme, server.IpAddr, server.Port, contextPath)
+		resp, err := s.agent.Get(url, http.Header{}, s.clientConfig.TimeoutMs, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s: unexpected status %s", server.IpAddr, resp.Status)
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no nacos server configured")
 	}
+	return lastErr
+}
 
-	//获取配置
-	err = configClient.ListenConfig(vo.ConfigParam{
+// listenPending 客户端已创建时监听尚未监听的 dataId，失败的 dataId 在下一次连接时重试
+func (s *Source) listenPending() {
+	s.mu.Lock()
+	configClient := s.configClient
+	pending := make(map[string]func(data []byte) error)
+	if configClient != nil {
+		for dataId, onChange := range s.listeners {
+			if !s.listening[dataId] {
+				s.listening[dataId] = true
+				pending[dataId] = onChange
+			}
+		}
+	}
+	s.mu.Unlock()
+	for dataId, onChange := range pending {
+		if err := s.listen(configClient, dataId, onChange); err != nil {
+			s.mu.Lock()
+			delete(s.listening, dataId)
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *Source) listen(configClient config_client.IConfigClient, dataId string, onChange func(data []byte) error) error {
+	err := configClient.ListenConfig(vo.ConfigParam{
 		DataId: dataId,
-		Group:  "DEFAULT_GROUP",
+		Group:  s.group,
 		OnChange: func(namespace, group, dataId, data string) {
-			zlog.Infof(defs.NACOS_LISTEN_CONFIG, "[ListenConfig]", "group:%s,dataId=%s,data=%s", group, dataId, data)
-			err = ioutil.WriteFile(filepath.Join(env.InstallDir, "cfg", "config.json"), []byte(data), 0600)
-			if err != nil {
-				zlog.Warnf(defs.NACOS_LISTEN_CONFIG, "[ListenConfig]", "write file to config.json,err:%v", err)
+			zlog.Infof(defs.NACOS_LISTEN_CONFIG, "[ListenConfig]", "group:%s,dataId=%s,data length=%d", group, dataId, len(data))
+			if err := onChange([]byte(data)); err != nil {
+				zlog.Warnf(defs.NACOS_LISTEN_CONFIG, "[ListenConfig]", "dataId=%s,err:%v", dataId, err)
 			}
-			zlog.Infof(defs.NACOS_LISTEN_CONFIG, "[ListenConfig]", "config update,jrasp-daemon will exit(0)...")
-			os.Exit(0)
 		},
 	})
+	if err != nil {
+		zlog.Warnf(defs.NACOS_INIT, "[ListenConfig]", "configClient.ListenConfig,dataId=%s,err:%v", dataId, err)
+	}
+	return err
+}
+
+// Listen 监听同一个命名空间下的其他 dataId，如远程命令；客户端未创建时在重连成功后监听
+func (s *Source) Listen(dataId string, onChange func(data []byte) error) error {
+	s.mu.Lock()
+	s.listeners[dataId] = onChange
+	s.mu.Unlock()
+	s.listenPending()
+	return nil
+}
+
+// CancelListen 取消 Listen
+func (s *Source) CancelListen(dataId string) {
+	s.mu.Lock()
+	configClient, listening := s.configClient, s.listening[dataId]
+	delete(s.listeners, dataId)
+	delete(s.listening, dataId)
+	s.mu.Unlock()
+	if configClient != nil && listening {
+		_ = configClient.CancelListenConfig(vo.ConfigParam{DataId: dataId, Group: s.group})
+	}
+}
+
+// Publish 发布配置，如远程命令的执行结果
+func (s *Source) Publish(dataId, content string) error {
+	s.mu.Lock()
+	configClient := s.configClient
+	s.mu.Unlock()
+	if configClient == nil {
+		return fmt.Errorf("nacos config client not started")
+	}
+	success, err := configClient.PublishConfig(vo.ConfigParam{DataId: dataId, Group: s.group, Content: content})
+	if err != nil {
+		return err
+	}
+	if !success {
+		return fmt.Errorf("publish %s failed", dataId)
+	}
+	return nil
+}
+
+// UpdateStatus 心跳时更新实例元数据，没有变化时不重新注册
+// 临时实例的元数据随注册信息一起上报，由 monitor 重新注册，不阻塞心跳
+func (s *Source) UpdateStatus(agentMode string, injectedJvms int, configVersion string) {
+	metadata := s.metadata(agentMode, injectedJvms, configVersion)
+	s.mu.Lock()
+	changed := !reflect.DeepEqual(metadata, s.instance.Metadata)
+	if changed {
+		s.instance.Metadata = metadata
+	}
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	select {
+	case s.refresh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Source) metadata(agentMode string, injectedJvms int, configVersion string) map[string]string {
+	return map[string]string{
+		"hostName":      s.env.HostName,
+		"daemonVersion": defs.JRASP_DAEMON_VERSION,
+		"agentMode":     agentMode,
+		"injectedJvms":  strconv.Itoa(injectedJvms),
+		"configVersion": configVersion,
+	}
+}
+
+// 相对路径基于安装目录
+func (s *Source) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(s.env.InstallDir, p)
+}
+
+// 使用自定义 http agent 创建客户端，sdk 的 clients.NewXxxClient 只能使用默认 agent
+func newNacosClient(clientConfig constant.ClientConfig, serverConfigs []constant.ServerConfig, agent http_agent.IHttpAgent) (*nacos_client.NacosClient, error) {
+	client := &nacos_client.NacosClient{}
+	if err := client.SetClientConfig(clientConfig); err != nil {
+		return nil, err
+	}
+	if err := client.SetServerConfig(serverConfigs); err != nil {
+		return nil, err
+	}
+	if err := client.SetHttpAgent(agent); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func newNamingClient(clientConfig constant.ClientConfig, serverConfigs []constant.ServerConfig, agent http_agent.IHttpAgent) (naming_client.INamingClient, error) {
+	client, err := newNacosClient(clientConfig, serverConfigs, agent)
+	if err != nil {
+		return nil, err
+	}
+	naming, err := naming_client.NewNamingClient(client)
+	if err != nil {
+		return nil, err
+	}
+	return &naming, nil
+}
 
+func newConfigClient(clientConfig constant.ClientConfig, serverConfigs []constant.ServerConfig, agent http_agent.IHttpAgent) (config_client.IConfigClient, error) {
+	client, err := newNacosClient(clientConfig, serverConfigs, agent)
 	if err != nil {
-		zlog.Warnf(defs.NACOS_INIT, "[ListenConfig]", "configClient.ListenConfig,err:%v", err)
+		return nil, err
+	}
+	return config_client.NewConfigClient(client)
+}
+
+// register 注册实例，返回是否成功
+func register(namingClient naming_client.INamingClient, instance vo.RegisterInstanceParam) bool {
+	success, err := namingClient.RegisterInstance(instance)
+	if err != nil || !success {
+		zlog.Warnf(defs.NACOS_INIT, "[registerStatus]", "registerStatus:%t,err:%v", success, err)
+	}
+	return err == nil && success
+}
+
+// Stop 注销实例并取消配置监听，nacos 控制台中不再出现已停止的主机
+func (s *Source) Stop() {
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		return
+	default:
+		close(s.done)
+	}
+	configClient, namingClient, registered, instance := s.configClient, s.namingClient, s.registered, s.instance
+	var listening []string
+	for dataId := range s.listening {
+		listening = append(listening, dataId)
+	}
+	s.registered = false
+	s.mu.Unlock()
+
+	if namingClient != nil && registered {
+		success, err := namingClient.DeregisterInstance(vo.DeregisterInstanceParam{
+			Ip:          instance.Ip,
+			Port:        instance.Port,
+			Cluster:     clusterName,
+			ServiceName: SERVICE_NAME,
+			GroupName:   s.group,
+			Ephemeral:   true,
+		})
+		if err != nil || !success {
+			zlog.Warnf(defs.NACOS_INIT, "[deregister]", "deregisterStatus:%t,err:%v", success, err)
+		} else {
+			zlog.Infof(defs.NACOS_INIT, "[deregister]", "deregister %s:%d success", instance.Ip, instance.Port)
+		}
+	}
+	if configClient != nil {
+		for _, dataId := range listening {
+			_ = configClient.CancelListenConfig(vo.ConfigParam{DataId: dataId, Group: s.group})
+		}
 	}
-	zlog.Infof(defs.NACOS_INIT, "[NacosInit]", "nacos init success")
 }
@@ -0,0 +1,219 @@
+package reload
+
+import (
+	"fmt"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/history"
+	"jrasp-daemon/update"
+	"jrasp-daemon/userconfig"
+	"jrasp-daemon/utils"
+	"jrasp-daemon/watch"
+	"jrasp-daemon/zlog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reloader 配置热更新：校验新配置、与运行中的配置比较，并在不重启进程的情况下应用变化
+type Reloader struct {
+	current *userconfig.Current // 当前的配置快照，变化时在 apply 中同步发布
+	env     *environ.Environ
+	watch   *watch.Watch
+	loader  *userconfig.Loader // 配置中心之外的其他来源保持不变
+	history *history.History   // 配置中心下发的历史版本
+	updater *update.Scheduler  // 可执行文件、模块与漏洞库更新
+	mu      sync.Mutex         // 配置变更串行处理
+}
+
+// 配置变更后的健康检查
+const (
+	healthWindow        = 5 * time.Minute  // 观察期，期间注入正常则标记为 good
+	healthCheckInterval = 30 * time.Second // 检查间隔
+	minInjectFailures   = 3                // 注入失败次数达到该值且多于成功次数时回滚
+)
+
+func NewReloader(current *userconfig.Current, env *environ.Environ, w *watch.Watch, loader *userconfig.Loader, h *history.History, updater *update.Scheduler) *Reloader {
+	return &Reloader{
+		current: current,
+		env:     env,
+		watch:   w,
+		loader:  loader,
+		history: h,
+		updater: updater,
+	}
+}
+
+// Reload 应用配置中心下发的配置
+func (r *Reloader) Reload(data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 已经回滚过的版本不再使用，避免重启后配置中心再次推送
+	if v, ok := r.history.Find(data); ok && v.Verdict == history.BAD {
+		zlog.Warnf(defs.CONFIG_ROLLBACK, "config version was rolled back,ignored", "version:%s,reason:%s", v.Version, v.Reason)
+		return fmt.Errorf("config version %s was rolled back: %s", v.Version, v.Reason)
+	}
+
+	// 配置中心下发的配置作为最高优先级的来源，与其他来源合并后校验
+	effective, err := r.loader.WithRemote(data).Load()
+	if err != nil {
+		// 配置不合法时继续使用当前配置
+		zlog.Errorf(defs.CONFIG_RELOAD, "[BUG]invalid config,ignored", "err:%v", err)
+		return err
+	}
+	newCfg := effective.Config
+	r.loader = r.loader.WithRemote(data)
+
+	version, switched, err := r.history.Record(data)
+	if err != nil {
+		zlog.Warnf(defs.CONFIG_RELOAD, "record config version failed", "err:%v", err)
+	} else {
+		zlog.Infof(defs.CONFIG_RELOAD, "config version", `{"version":"%s","verdict":"%s"}`, version.Version, version.Verdict)
+		// 重复下发当前版本(如启动后配置中心的首次推送)时，启动时已经开始观察，不再重复观察
+		if switched && version.Verdict == history.PENDING {
+			go r.MonitorHealth(version)
+		}
+	}
+
+	// 先校验再落盘，落盘使用原子写，避免进程退出时缓存文件不完整
+	remoteFile := userconfig.RemoteCacheFile(r.env.InstallDir)
+	if err := utils.WriteFileAtomic(remoteFile, data, 0600); err != nil {
+		zlog.Warnf(defs.CONFIG_RELOAD, "write remote config cache failed", "file:%s,err:%v", remoteFile, err)
+	}
+	if err := userconfig.WriteEffective(r.env.InstallDir, effective); err != nil {
+		zlog.Warnf(defs.CONFIG_RELOAD, "write effective config failed", "err:%v", err)
+	}
+
+	changed := userconfig.Diff(r.current.Load(), newCfg)
+	if len(changed) == 0 {
+		zlog.Infof(defs.CONFIG_RELOAD, "config not changed", "")
+		return nil
+	}
+	r.apply(newCfg, changed)
+	return nil
+}
+
+// MonitorHealth 观察期内注入失败激增时回滚到上一个 good 版本，观察期结束后标记为 good
+// 启动时当前版本仍在观察中也需要调用
+func (r *Reloader) MonitorHealth(v history.Version) {
+	success0, failed0 := r.watch.InjectStats()
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	deadline := time.Now().Add(healthWindow)
+	for range ticker.C {
+		if current, ok := r.history.Current(); !ok || current.Version != v.Version {
+			return // 已有更新的版本
+		}
+		success, failed := r.watch.InjectStats()
+		if failed-failed0 >= minInjectFailures && failed-failed0 > success-success0 {
+			r.rollback(v, fmt.Sprintf("inject failures spiked: %d failed, %d succeeded", failed-failed0, success-success0))
+			return
+		}
+		if time.Now().After(deadline) {
+			if err := r.history.MarkGood(v.Version); err != nil {
+				zlog.Warnf(defs.CONFIG_RELOAD, "mark config version good failed", "version:%s,err:%v", v.Version, err)
+				return
+			}
+			zlog.Infof(defs.CONFIG_RELOAD, "config version is healthy", `{"version":"%s","injectSuccess":%d,"injectFailed":%d}`, v.Version, success-success0, failed-failed0)
+			return
+		}
+	}
+}
+
+func (r *Reloader) rollback(bad history.Version, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if current, ok := r.history.Current(); !ok || current.Version != bad.Version {
+		return
+	}
+	event, err := r.history.Rollback(reason)
+	if err != nil {
+		zlog.Errorf(defs.CONFIG_ROLLBACK, "[BUG]config rollback failed", "version:%s,err:%v", bad.Version, err)
+		return
+	}
+	LogRollback(event)
+
+	var data []byte
+	if event.GoodVersion != "" {
+		data, _ = r.history.Data(event.GoodVersion)
+	}
+	r.loader = r.loader.WithRemote(data)
+	effective, err := r.loader.Load()
+	if err != nil {
+		zlog.Errorf(defs.CONFIG_ROLLBACK, "[BUG]load rollback config failed", "version:%s,err:%v", event.GoodVersion, err)
+		return
+	}
+	if err := userconfig.WriteEffective(r.env.InstallDir, effective); err != nil {
+		zlog.Warnf(defs.CONFIG_RELOAD, "write effective config failed", "err:%v", err)
+	}
+	if changed := userconfig.Diff(r.current.Load(), effective.Config); len(changed) > 0 {
+		r.apply(effective.Config, changed)
+	}
+}
+
+// LogRollback 输出回滚事件
+func LogRollback(event *history.RollbackEvent) {
+	zlog.Warnf(defs.CONFIG_ROLLBACK, "config rollback", utils.ToString(event))
+}
+
+// RefreshSecrets 定时重新解析密钥引用，密钥文件、环境变量或加密值变化(密钥轮换)后推送给运行中的agent
+// 只应用密钥相关的变化，其他配置仍以配置中心下发为准
+func (r *Reloader) RefreshSecrets(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.refreshSecrets()
+	}
+}
+
+func (r *Reloader) refreshSecrets() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	effective, err := r.loader.Load()
+	if err != nil {
+		zlog.Warnf(defs.CONFIG_RELOAD, "refresh secrets failed", "err:%v", err)
+		return
+	}
+	changed := userconfig.Diff(r.current.Load(), effective.Config)
+	if len(changed) == 0 {
+		return
+	}
+	secrets := userconfig.SecretFields()
+	for _, field := range changed {
+		if !userconfig.Contains(secrets, field) {
+			return
+		}
+	}
+	zlog.Infof(defs.CONFIG_RELOAD, "secret rotated", "fields:%s", strings.Join(changed, ","))
+	r.apply(effective.Config, changed)
+}
+
+func (r *Reloader) apply(newCfg *userconfig.Config, changed []string) {
+	zlog.Infof(defs.CONFIG_RELOAD, "config changed", "fields:%s", strings.Join(changed, ","))
+
+	if restart := userconfig.NeedRestart(changed); len(restart) > 0 {
+		zlog.Infof(defs.CONFIG_RELOAD, "config need restart", "fields:%s,jrasp-daemon will exit(0)...", strings.Join(restart, ","))
+		history.MarkCleanExit(r.env.InstallDir)
+		os.Exit(0)
+	}
+
+	// 先发布新的快照，下一次变更与新快照比较，不依赖注入协程是否已经应用
+	r.current.Store(newCfg)
+
+	if userconfig.Contains(changed, "logLevel") {
+		zlog.SetLevel(newCfg.LogLevel)
+	}
+
+	// 先下载模块、漏洞库，再通知运行中的JVM刷新模块、更新参数
+	// 可执行文件更新成功后进程退出，由 systemd 拉起新版本
+	for _, field := range []string{"moduleConfigMap", "vulnDbConfig", "exeOssFileName", "exeOssFileHash", "updateTicker", "download"} {
+		if userconfig.Contains(changed, field) {
+			r.updater.Check(newCfg)
+			break
+		}
+	}
+
+	r.watch.Reload(newCfg)
+}
@@ -0,0 +1,275 @@
+package report
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/java_process"
+	"jrasp-daemon/userconfig"
+	"jrasp-daemon/zlog"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SCHEMA_VERSION 上报内容的格式版本，字段只增不减；不兼容的修改需要升级主版本
+const SCHEMA_VERSION = "1.0"
+
+// 事件类型
+const (
+	HEARTBEAT     = "heartbeat"     // data: watch.HeartBeatInfo
+	PROCESS       = "process"       // data: java_process.JavaProcess
+	DEPENDENCY    = "dependency"    // data: Dependencies
+	UPGRADE       = "upgrade"       // data: update.UpgradeFailedEvent
+	VULNERABILITY = "vulnerability" // data: vuln.Finding
+)
+
+const (
+	minBackoff  = time.Second      // 第一次重试前的等待时间，之后每次翻倍
+	stopTimeout = 10 * time.Second // 退出时发送剩余事件的最长时间
+)
+
+// Batch 一次请求的内容，请求体使用 gzip 压缩
+type Batch struct {
+	SchemaVersion string    `json:"schemaVersion"`
+	HostName      string    `json:"hostName"`
+	Ip            string    `json:"ip"`
+	DaemonVersion string    `json:"daemonVersion"`
+	SentAt        time.Time `json:"sentAt"`
+	Events        []Event   `json:"events"`
+}
+
+// Event 单个事件，data 的格式由 type 决定
+type Event struct {
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Dependencies 一个应用的依赖
+type Dependencies struct {
+	AppId        string                    `json:"appId"`
+	Pids         []int32                   `json:"pids"`
+	Jdk          java_process.JdkInfo      `json:"jdk"`
+	Dependencies []java_process.Dependency `json:"dependencies"`
+}
+
+// Client 异步批量上报，队列满时丢弃最早的事件，不阻塞调用方
+type Client struct {
+	cfg     userconfig.ReportConfig
+	env     *environ.Environ
+	client  *http.Client
+	queue   chan Event
+	dropped uint64 // 队列满或重试失败丢弃的事件数
+	done    chan struct{}
+	wg      sync.WaitGroup
+	ctx     context.Context // Stop 超时后取消，中止进行中的请求与重试
+	cancel  context.CancelFunc
+
+	minBackoff  time.Duration
+	stopTimeout time.Duration
+}
+
+// NewClient 未配置 url 时返回 nil，nil 的 Client 可以直接调用 Send、Stop
+func NewClient(cfg *userconfig.Config, env *environ.Environ) *Client {
+	if cfg.Report.URL == "" {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		cfg:    cfg.Report,
+		env:    env,
+		client: &http.Client{Timeout: 30 * time.Second},
+		queue:  make(chan Event, cfg.Report.QueueSize),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+
+		minBackoff:  minBackoff,
+		stopTimeout: stopTimeout,
+	}
+}
+
+// Send 序列化后放入队列，data 可以在返回后修改
+func (c *Client) Send(eventType string, data interface{}) {
+	if c == nil {
+		return
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		zlog.Warnf(defs.UPLOAD, "[report]", "marshal %s event,err:%v", eventType, err)
+		return
+	}
+	event := Event{Type: eventType, Time: time.Now(), Data: raw}
+	for {
+		select {
+		case c.queue <- event:
+			return
+		default:
+		}
+		// 队列已满，丢弃最早的事件
+		select {
+		case <-c.queue:
+			atomic.AddUint64(&c.dropped, 1)
+		default:
+		}
+	}
+}
+
+func (c *Client) Start() {
+	if c == nil {
+		return
+	}
+	c.wg.Add(1)
+	go c.run()
+	zlog.Infof(defs.UPLOAD, "[report]", "report to %s,schemaVersion:%s", c.cfg.URL, SCHEMA_VERSION)
+}
+
+// Stop 发送队列中剩余的事件后返回，最多等待 stopTimeout，collector 不可达时不阻塞退出
+func (c *Client) Stop() {
+	if c == nil {
+		return
+	}
+	close(c.done)
+	finished := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(c.stopTimeout):
+		c.cancel()
+		<-finished
+	}
+	c.cancel()
+}
+
+func (c *Client) run() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(time.Duration(c.cfg.FlushInterval) * time.Second)
+	defer ticker.Stop()
+	var events []Event
+	for {
+		select {
+		case event := <-c.queue:
+			events = append(events, event)
+			if len(events) >= c.cfg.BatchSize {
+				c.flush(events)
+				events = nil
+			}
+		case <-ticker.C:
+			if len(events) > 0 {
+				c.flush(events)
+				events = nil
+			}
+		case <-c.done:
+			c.drain(events)
+			return
+		}
+	}
+}
+
+// drain 退出时分批发送剩余的事件，Stop 超时后丢弃
+func (c *Client) drain(events []Event) {
+	for len(c.queue) > 0 {
+		events = append(events, <-c.queue)
+	}
+	for len(events) > 0 {
+		if c.ctx.Err() != nil {
+			atomic.AddUint64(&c.dropped, uint64(len(events)))
+			zlog.Warnf(defs.UPLOAD, "[report]", "stop timeout,%d events dropped", len(events))
+			return
+		}
+		n := len(events)
+		if n > c.cfg.BatchSize {
+			n = c.cfg.BatchSize
+		}
+		c.flush(events[:n])
+		events = events[n:]
+	}
+}
+
+// flush 发送一批事件，网络错误、5xx 与 429 按指数退避重试，Stop 超时后不再重试
+func (c *Client) flush(events []Event) {
+	batch := Batch{
+		SchemaVersion: SCHEMA_VERSION,
+		HostName:      c.env.HostName,
+		Ip:            c.env.Ip,
+		DaemonVersion: defs.JRASP_DAEMON_VERSION,
+		SentAt:        time.Now(),
+		Events:        events,
+	}
+	body, err := encode(batch)
+	if err != nil {
+		zlog.Warnf(defs.UPLOAD, "[report]", "encode batch,err:%v", err)
+		return
+	}
+	backoff := c.minBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := c.post(body)
+		if err == nil {
+			if dropped := atomic.SwapUint64(&c.dropped, 0); dropped > 0 {
+				zlog.Warnf(defs.UPLOAD, "[report]", "%d events dropped before this batch", dropped)
+			}
+			return
+		}
+		if !retry || attempt >= c.cfg.MaxRetries || c.ctx.Err() != nil {
+			atomic.AddUint64(&c.dropped, uint64(len(events)))
+			zlog.Warnf(defs.UPLOAD, "[report]", "send %d events failed after %d attempts,err:%v", len(events), attempt+1, err)
+			return
+		}
+		// 加入随机抖动，避免大量主机同时重试
+		select {
+		case <-time.After(backoff + time.Duration(rand.Int63n(int64(backoff)))):
+		case <-c.ctx.Done():
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-Schema-Version", SCHEMA_VERSION)
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	switch {
+	case resp.StatusCode/100 == 2:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+func encode(batch Batch) ([]byte, error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gw).Encode(batch); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,192 @@
+package report
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/userconfig"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// collector 记录收到的批次，statuses 依次作为前几次请求的响应码
+type collector struct {
+	t        *testing.T
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	batches  []Batch
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	if len(c.statuses) > 0 {
+		status := c.statuses[0]
+		c.statuses = c.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	if got := r.Header.Get("Content-Encoding"); got != "gzip" {
+		c.t.Errorf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := r.Header.Get("X-Schema-Version"); got != SCHEMA_VERSION {
+		c.t.Errorf("X-Schema-Version = %q, want %s", got, SCHEMA_VERSION)
+	}
+	if got := r.Header.Get("Authorization"); got != "Bearer token" {
+		c.t.Errorf("Authorization = %q", got)
+	}
+	gr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		c.t.Errorf("body is not gzip: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var batch Batch
+	if err := json.NewDecoder(gr).Decode(&batch); err != nil {
+		c.t.Errorf("decode batch: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.batches = append(c.batches, batch)
+}
+
+func newTestClient(t *testing.T, handler http.Handler, batchSize, maxRetries int) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	cfg := &userconfig.Config{Report: userconfig.ReportConfig{
+		URL:           server.URL,
+		Token:         "token",
+		BatchSize:     batchSize,
+		FlushInterval: 60,
+		QueueSize:     100,
+		MaxRetries:    maxRetries,
+	}}
+	c := NewClient(cfg, &environ.Environ{HostName: "host-1", Ip: "10.0.0.1"})
+	c.minBackoff = 10 * time.Millisecond
+	return c
+}
+
+func testEvents(n int) []Event {
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = Event{Type: PROCESS, Time: time.Now(), Data: json.RawMessage(`{}`)}
+	}
+	return events
+}
+
+func TestClientBatching(t *testing.T) {
+	cs := &collector{t: t}
+	c := newTestClient(t, cs, 2, 0)
+	for i := 0; i < 5; i++ {
+		c.Send(DEPENDENCY, Dependencies{AppId: "app", Pids: []int32{int32(i)}})
+	}
+	c.Start()
+	c.Stop()
+
+	var sizes []int
+	var pids []int32
+	for _, batch := range cs.batches {
+		if batch.SchemaVersion != SCHEMA_VERSION || batch.HostName != "host-1" || batch.Ip != "10.0.0.1" {
+			t.Errorf("unexpected batch header %+v", batch)
+		}
+		sizes = append(sizes, len(batch.Events))
+		for _, event := range batch.Events {
+			if event.Type != DEPENDENCY {
+				t.Errorf("event type = %q, want %s", event.Type, DEPENDENCY)
+			}
+			var deps Dependencies
+			if err := json.Unmarshal(event.Data, &deps); err != nil {
+				t.Fatal(err)
+			}
+			pids = append(pids, deps.Pids...)
+		}
+	}
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Fatalf("batch sizes = %v, want [2 2 1]", sizes)
+	}
+	for i, pid := range pids {
+		if pid != int32(i) {
+			t.Fatalf("events out of order: %v", pids)
+		}
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		cs := &collector{t: t, statuses: []int{status, status}}
+		c := newTestClient(t, cs, 10, 3)
+		c.flush(testEvents(1))
+		if cs.requests != 3 || len(cs.batches) != 1 {
+			t.Fatalf("status %d: requests = %d, batches = %d, want 3 and 1", status, cs.requests, len(cs.batches))
+		}
+		if dropped := atomic.LoadUint64(&c.dropped); dropped != 0 {
+			t.Fatalf("status %d: dropped = %d", status, dropped)
+		}
+	}
+}
+
+func TestClientRetryLimit(t *testing.T) {
+	cs := &collector{t: t, statuses: []int{500, 500, 500, 500}}
+	c := newTestClient(t, cs, 10, 2)
+	c.flush(testEvents(3))
+	if cs.requests != 3 {
+		t.Fatalf("requests = %d, want 3", cs.requests)
+	}
+	if dropped := atomic.LoadUint64(&c.dropped); dropped != 3 {
+		t.Fatalf("dropped = %d, want 3", dropped)
+	}
+}
+
+func TestClientNoRetryOn4xx(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized} {
+		cs := &collector{t: t, statuses: []int{status}}
+		c := newTestClient(t, cs, 10, 3)
+		c.flush(testEvents(2))
+		if cs.requests != 1 {
+			t.Fatalf("status %d: requests = %d, want 1", status, cs.requests)
+		}
+		if dropped := atomic.LoadUint64(&c.dropped); dropped != 2 {
+			t.Fatalf("status %d: dropped = %d, want 2", status, dropped)
+		}
+	}
+}
+
+func TestClientStopDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	hang := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	c := newTestClient(t, hang, 1, 3)
+	c.stopTimeout = 200 * time.Millisecond
+	for i := 0; i < 5; i++ {
+		c.Send(PROCESS, struct{}{})
+	}
+	c.Start()
+	start := time.Now()
+	c.Stop()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Stop took %s, want about %s", elapsed, c.stopTimeout)
+	}
+}
+
+func TestNilClient(t *testing.T) {
+	c := NewClient(&userconfig.Config{}, &environ.Environ{})
+	if c != nil {
+		t.Fatal("want nil client without url")
+	}
+	c.Send(HEARTBEAT, struct{}{})
+	c.Start()
+	c.Stop()
+}
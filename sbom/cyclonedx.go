@@ -0,0 +1,133 @@
+package sbom
+
+import (
+	"fmt"
+	"jrasp-daemon/defs"
+	"strings"
+	"time"
+)
+
+// CycloneDX 1.4 json 格式(https://cyclonedx.org/docs/1.4/json/)
+type cdxBom struct {
+	BomFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     []cdxTool    `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTool struct {
+	Vendor  string `json:"vendor"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type cdxComponent struct {
+	Type       string         `json:"type"`
+	BomRef     string         `json:"bom-ref,omitempty"`
+	Group      string         `json:"group,omitempty"`
+	Name       string         `json:"name"`
+	Version    string         `json:"version,omitempty"`
+	Publisher  string         `json:"publisher,omitempty"`
+	Purl       string         `json:"purl,omitempty"`
+	Hashes     []cdxHash      `json:"hashes,omitempty"`
+	Properties []cdxProperty  `json:"properties,omitempty"`
+	Components []cdxComponent `json:"components,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// newCycloneDX 单个JVM时根组件为应用，主机维度时根组件为主机，各应用作为子组件
+func newCycloneDX(hostName string, apps []app, host bool) *cdxBom {
+	bom := &cdxBom{
+		BomFormat:    "CycloneDX",
+		SpecVersion:  "1.4",
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools:     []cdxTool{{Vendor: "jrasp", Name: "jrasp-daemon", Version: defs.JRASP_DAEMON_VERSION}},
+		},
+	}
+	if host {
+		bom.Metadata.Component = cdxComponent{Type: "device", BomRef: "host", Name: hostName}
+		for _, a := range apps {
+			appComponent := cdxAppComponent(hostName, a)
+			appComponent.Components = cdxAppChildren(a)
+			bom.Components = append(bom.Components, appComponent)
+		}
+		return bom
+	}
+	if len(apps) == 1 {
+		bom.Metadata.Component = cdxAppComponent(hostName, apps[0])
+		bom.Components = cdxAppChildren(apps[0])
+	}
+	return bom
+}
+
+func cdxAppComponent(hostName string, a app) cdxComponent {
+	pids := make([]string, 0, len(a.Pids))
+	for _, pid := range a.Pids {
+		pids = append(pids, fmt.Sprintf("%d", pid))
+	}
+	return cdxComponent{
+		Type:   "application",
+		BomRef: "app:" + a.AppId,
+		Name:   a.AppId,
+		Properties: []cdxProperty{
+			{Name: "jrasp:hostName", Value: hostName},
+			{Name: "jrasp:pids", Value: strings.Join(pids, ",")},
+		},
+	}
+}
+
+// cdxAppChildren jdk 与全部jar包
+func cdxAppChildren(a app) []cdxComponent {
+	var list []cdxComponent
+	if a.Jdk.Home != "" {
+		list = append(list, cdxComponent{
+			Type:       "platform",
+			BomRef:     "app:" + a.AppId + ":jdk",
+			Name:       "jdk",
+			Version:    a.Jdk.Version,
+			Publisher:  a.Jdk.Vendor,
+			Properties: []cdxProperty{{Name: "jrasp:javaHome", Value: a.Jdk.Home}},
+		})
+	}
+	for _, c := range a.Components {
+		component := cdxComponent{
+			Type:    "library",
+			BomRef:  "app:" + a.AppId + ":" + c.Vendor + ":" + c.Name() + ":" + c.Version + ":" + c.Path,
+			Group:   c.Vendor,
+			Name:    c.Name(),
+			Version: c.Version,
+			Purl:    c.Purl(),
+		}
+		if c.Sha1 != "" {
+			component.Hashes = append(component.Hashes, cdxHash{Alg: "SHA-1", Content: c.Sha1})
+		}
+		if c.Sha256 != "" {
+			component.Hashes = append(component.Hashes, cdxHash{Alg: "SHA-256", Content: c.Sha256})
+		}
+		if c.Path != "" {
+			component.Properties = append(component.Properties, cdxProperty{Name: "jrasp:path", Value: c.Path})
+		}
+		list = append(list, component)
+	}
+	return list
+}
@@ -0,0 +1,211 @@
+package sbom
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"jrasp-daemon/java_process"
+	"jrasp-daemon/utils"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// 导出格式
+const (
+	CycloneDX = "cyclonedx"
+	SPDX      = "spdx"
+)
+
+// Jvm 生成 sbom 需要的单个应用信息
+type Jvm struct {
+	AppId        string
+	Pids         []int32
+	Jdk          java_process.JdkInfo
+	Dependencies []java_process.Dependency
+}
+
+// Component 两种格式共用的组件信息
+type Component struct {
+	Vendor  string
+	Product string
+	Version string
+	Path    string
+	Sha1    string
+	Sha256  string
+}
+
+// Purl maven 组件的 package url
+func (c Component) Purl() string {
+	if c.Vendor == "" || c.Product == "" {
+		return ""
+	}
+	purl := fmt.Sprintf("pkg:maven/%s/%s", url.PathEscape(c.Vendor), url.PathEscape(c.Product))
+	if c.Version != "" {
+		purl += "@" + url.PathEscape(c.Version)
+	}
+	return purl
+}
+
+// Name 组件名称，依赖信息不完整时使用jar包文件名
+func (c Component) Name() string {
+	if c.Product != "" {
+		return c.Product
+	}
+	return filepath.Base(c.Path)
+}
+
+// Generator 生成并保存 sbom
+type Generator struct {
+	dir      string
+	hostName string
+	digests  *utils.DigestCache // jar包hash缓存
+}
+
+func NewGenerator(installDir, hostName string, digests *utils.DigestCache) *Generator {
+	return &Generator{
+		dir:      Dir(installDir),
+		hostName: hostName,
+		digests:  digests,
+	}
+}
+
+// Dir sbom 文件目录
+func Dir(installDir string) string {
+	return filepath.Join(installDir, "sbom")
+}
+
+// FileName sbom 文件名，id 为应用标识或 host
+func FileName(id, format string) string {
+	name := "host"
+	if id != "host" {
+		name = utils.SafeFileName(id)
+	}
+	if format == SPDX {
+		return name + ".spdx.json"
+	}
+	return name + ".cdx.json"
+}
+
+// components jar包路径已知时计算hash
+func (g *Generator) components(deps []java_process.Dependency) []Component {
+	list := make([]Component, 0, len(deps))
+	seen := make(map[string]bool)
+	for _, d := range deps {
+		// 不同容器中相同的路径是不同的文件
+		root := java_process.ProcRoot(d.Pid)
+		key := d.Vendor + ":" + d.Product + ":" + d.Version + ":" + root + d.Path
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		c := Component{Vendor: d.Vendor, Product: d.Product, Version: d.Version, Path: d.Path}
+		c.Sha1, c.Sha256 = g.digests.Get(root, d.Path)
+		if c.Sha256 == "" {
+			c.Sha256 = d.Hash // 嵌套jar包在磁盘扫描时计算
+		}
+		list = append(list, c)
+	}
+	return list
+}
+
+// Write 生成单个JVM与主机维度的 CycloneDX、SPDX 文件
+func (g *Generator) Write(jvms []Jvm) error {
+	if err := os.MkdirAll(g.dir, 0700); err != nil {
+		return err
+	}
+	hostApps := make([]app, 0, len(jvms))
+	for _, jvm := range jvms {
+		a := app{Jvm: jvm, Components: g.components(jvm.Dependencies)}
+		hostApps = append(hostApps, a)
+		if err := g.writeFile(FileName(jvm.AppId, CycloneDX), newCycloneDX(g.hostName, []app{a}, false)); err != nil {
+			return err
+		}
+		if err := g.writeFile(FileName(jvm.AppId, SPDX), newSpdx(g.hostName, []app{a}, false)); err != nil {
+			return err
+		}
+	}
+	if err := g.writeFile(FileName("host", CycloneDX), newCycloneDX(g.hostName, hostApps, true)); err != nil {
+		return err
+	}
+	if err := g.writeFile(FileName("host", SPDX), newSpdx(g.hostName, hostApps, true)); err != nil {
+		return err
+	}
+	index := make(map[string][]int32, len(jvms))
+	for _, jvm := range jvms {
+		index[jvm.AppId] = jvm.Pids
+	}
+	return g.writeFile(indexFileName, index)
+}
+
+// 应用标识与进程的索引，用于按pid查找 sbom 文件
+const indexFileName = "index.json"
+
+// ReadIndex 读取应用标识与进程的索引
+func ReadIndex(installDir string) (map[string][]int32, error) {
+	data, err := ioutil.ReadFile(filepath.Join(Dir(installDir), indexFileName))
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string][]int32)
+	err = json.Unmarshal(data, &index)
+	return index, err
+}
+
+// ReadFile 读取最近一次生成的 sbom，target 为 host、应用标识或 pid
+func ReadFile(installDir, target, format string) ([]byte, error) {
+	if format != CycloneDX && format != SPDX {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	id := target
+	if pid, err := strconv.Atoi(target); err == nil {
+		id, err = appIdOfPid(installDir, int32(pid))
+		if err != nil {
+			return nil, err
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(Dir(installDir), FileName(id, format)))
+	if err != nil {
+		return nil, fmt.Errorf("read sbom of %s: %v", target, err)
+	}
+	return data, nil
+}
+
+func appIdOfPid(installDir string, pid int32) (string, error) {
+	index, err := ReadIndex(installDir)
+	if err != nil {
+		return "", fmt.Errorf("read sbom index: %v", err)
+	}
+	for appId, pids := range index {
+		for _, p := range pids {
+			if p == pid {
+				return appId, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no sbom for pid %d", pid)
+}
+
+func (g *Generator) writeFile(name string, doc interface{}) error {
+	data, err := utils.MarshalIndent(doc)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(g.dir, name), data, 0600)
+}
+
+// app 一个应用及其组件
+type app struct {
+	Jvm
+	Components []Component
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
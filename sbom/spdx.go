@@ -0,0 +1,150 @@
+package sbom
+
+import (
+	"fmt"
+	"jrasp-daemon/defs"
+	"regexp"
+	"time"
+)
+
+// SPDX 2.3 json 格式(https://spdx.github.io/spdx-spec/v2.3/)
+type spdxDocument struct {
+	SpdxVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	Supplier         string            `json:"supplier,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	PrimaryPurpose   string            `json:"primaryPackagePurpose,omitempty"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+	Comment          string            `json:"comment,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SpdxElementId      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSpdxElement string `json:"relatedSpdxElement"`
+}
+
+// SPDXID 只允许字母、数字、"." 和 "-"
+var spdxIdChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+func spdxId(parts ...interface{}) string {
+	return "SPDXRef-" + spdxIdChars.ReplaceAllString(fmt.Sprint(parts...), "-")
+}
+
+func newSpdx(hostName string, apps []app, host bool) *spdxDocument {
+	name := hostName
+	if !host && len(apps) == 1 {
+		name = apps[0].AppId
+	}
+	doc := &spdxDocument{
+		SpdxVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: "https://jrasp.com/spdx/" + spdxIdChars.ReplaceAllString(name, "-") + "-" + newUUID(),
+		CreationInfo: spdxCreationInfo{
+			Created:  time.Now().UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: jrasp-daemon-" + defs.JRASP_DAEMON_VERSION},
+		},
+	}
+	root := "SPDXRef-DOCUMENT"
+	if host {
+		root = spdxId("host")
+		doc.Packages = append(doc.Packages, spdxPackage{
+			Name:             hostName,
+			SPDXID:           root,
+			DownloadLocation: "NOASSERTION",
+			PrimaryPurpose:   "DEVICE",
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{"SPDXRef-DOCUMENT", "DESCRIBES", root})
+	}
+	for i, a := range apps {
+		appId := spdxId("app-", i)
+		doc.Packages = append(doc.Packages, spdxPackage{
+			Name:             a.AppId,
+			SPDXID:           appId,
+			DownloadLocation: "NOASSERTION",
+			PrimaryPurpose:   "APPLICATION",
+			Comment:          fmt.Sprintf("host: %s, pids: %v", hostName, a.Pids),
+		})
+		if host {
+			doc.Relationships = append(doc.Relationships, spdxRelationship{root, "CONTAINS", appId})
+		} else {
+			doc.Relationships = append(doc.Relationships, spdxRelationship{root, "DESCRIBES", appId})
+		}
+		if a.Jdk.Home != "" {
+			jdkId := spdxId("app-", i, "-jdk")
+			pkg := spdxPackage{
+				Name:             "jdk",
+				SPDXID:           jdkId,
+				VersionInfo:      a.Jdk.Version,
+				DownloadLocation: "NOASSERTION",
+				PrimaryPurpose:   "FRAMEWORK",
+				Comment:          "java home: " + a.Jdk.Home,
+			}
+			if a.Jdk.Vendor != "" {
+				pkg.Supplier = "Organization: " + a.Jdk.Vendor
+			}
+			doc.Packages = append(doc.Packages, pkg)
+			doc.Relationships = append(doc.Relationships, spdxRelationship{appId, "DEPENDS_ON", jdkId})
+		}
+		for j, c := range a.Components {
+			pkgId := spdxId("app-", i, "-lib-", j)
+			pkg := spdxPackage{
+				Name:             c.Name(),
+				SPDXID:           pkgId,
+				VersionInfo:      c.Version,
+				DownloadLocation: "NOASSERTION",
+				PrimaryPurpose:   "LIBRARY",
+			}
+			if c.Vendor != "" {
+				pkg.Supplier = "Organization: " + c.Vendor
+			}
+			if c.Sha1 != "" {
+				pkg.Checksums = append(pkg.Checksums, spdxChecksum{"SHA1", c.Sha1})
+			}
+			if c.Sha256 != "" {
+				pkg.Checksums = append(pkg.Checksums, spdxChecksum{"SHA256", c.Sha256})
+			}
+			if purl := c.Purl(); purl != "" {
+				pkg.ExternalRefs = append(pkg.ExternalRefs, spdxExternalRef{"PACKAGE-MANAGER", "purl", purl})
+			}
+			if c.Path != "" {
+				pkg.Comment = "path: " + c.Path
+			}
+			doc.Packages = append(doc.Packages, pkg)
+			doc.Relationships = append(doc.Relationships, spdxRelationship{appId, "CONTAINS", pkgId})
+		}
+	}
+	return doc
+}
@@ -0,0 +1,238 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"jrasp-daemon/utils"
+	"jrasp-daemon/zlog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 密钥引用格式，配置文件与配置中心只保存引用，不保存明文
+const (
+	PREFIX_FILE = "file:" // file:cfg/agent.password，相对路径基于安装目录，文件权限必须为 0600
+	PREFIX_ENV  = "env:"  // env:JRASP_AGENT_PASSWORD
+	PREFIX_ENC  = "enc:"  // enc:base64(nonce+密文)，使用主机密钥 AES-256-GCM 加密
+)
+
+// REDACTED 日志与配置输出中替换明文的字符串
+const REDACTED = "******"
+
+const hostKeySize = 32
+
+// HostKeyFile 主机密钥，只在本机保存
+func HostKeyFile(installDir string) string {
+	return filepath.Join(installDir, "cfg", "host.key")
+}
+
+// AgentPasswordFile 默认的 agent 登录密码文件，首次启动时随机生成
+func AgentPasswordFile(installDir string) string {
+	return filepath.Join(installDir, "cfg", "agent.password")
+}
+
+// IsReference 是否为密钥引用
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, PREFIX_FILE) || strings.HasPrefix(value, PREFIX_ENV) || strings.HasPrefix(value, PREFIX_ENC)
+}
+
+// CheckReference 只检查引用格式，不读取密钥
+func CheckReference(value string) error {
+	switch {
+	case strings.HasPrefix(value, PREFIX_FILE):
+		if strings.TrimPrefix(value, PREFIX_FILE) == "" {
+			return fmt.Errorf("missing file path after %q", PREFIX_FILE)
+		}
+	case strings.HasPrefix(value, PREFIX_ENV):
+		if strings.TrimPrefix(value, PREFIX_ENV) == "" {
+			return fmt.Errorf("missing env name after %q", PREFIX_ENV)
+		}
+	case strings.HasPrefix(value, PREFIX_ENC):
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, PREFIX_ENC))
+		if err != nil {
+			return fmt.Errorf("encrypted value must be base64: %v", err)
+		}
+		if len(data) <= 12 {
+			return fmt.Errorf("encrypted value too short")
+		}
+	}
+	return nil
+}
+
+// Redact 输出配置时隐藏明文，引用本身不是密钥，原样输出便于排查
+func Redact(value string) string {
+	if value == "" || IsReference(value) {
+		return value
+	}
+	return REDACTED
+}
+
+// Resolver 解析密钥引用，解析出的明文注册到日志脱敏
+type Resolver struct {
+	installDir string
+}
+
+func NewResolver(installDir string) *Resolver {
+	return &Resolver{installDir: installDir}
+}
+
+// Resolve 返回密钥明文，非引用的值视为明文(兼容旧配置)
+func (r *Resolver) Resolve(value string) (string, error) {
+	var plain string
+	switch {
+	case strings.HasPrefix(value, PREFIX_FILE):
+		v, err := r.readFile(strings.TrimPrefix(value, PREFIX_FILE))
+		if err != nil {
+			return "", err
+		}
+		plain = v
+	case strings.HasPrefix(value, PREFIX_ENV):
+		name := strings.TrimPrefix(value, PREFIX_ENV)
+		v, ok := os.LookupEnv(name)
+		if !ok || v == "" {
+			return "", fmt.Errorf("secret env %s is not set", name)
+		}
+		plain = v
+	case strings.HasPrefix(value, PREFIX_ENC):
+		v, err := r.decrypt(strings.TrimPrefix(value, PREFIX_ENC))
+		if err != nil {
+			return "", err
+		}
+		plain = v
+	default:
+		plain = value
+	}
+	if len(plain) < zlog.MinSecretLen {
+		return "", fmt.Errorf("secret must be at least %d characters", zlog.MinSecretLen)
+	}
+	zlog.AddSecret(plain)
+	return plain, nil
+}
+
+func (r *Resolver) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(r.installDir, p)
+}
+
+// readFile 读取密钥文件，group/other 有任何权限时拒绝使用
+func (r *Resolver) readFile(p string) (string, error) {
+	p = r.path(p)
+	info, err := os.Stat(p)
+	if err != nil {
+		return "", fmt.Errorf("secret file: %v", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("secret file %s permissions %#o are too open, must be 0600", p, info.Mode().Perm())
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", fmt.Errorf("secret file: %v", err)
+	}
+	v := strings.TrimRight(string(data), "\r\n")
+	if v == "" {
+		return "", fmt.Errorf("secret file %s is empty", p)
+	}
+	return v, nil
+}
+
+func (r *Resolver) decrypt(encoded string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("encrypted secret: %v", err)
+	}
+	key, err := readKey(HostKeyFile(r.installDir))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) <= gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted secret too short")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret with host key failed, was it encrypted on another host?")
+	}
+	return string(plain), nil
+}
+
+// Encrypt 使用主机密钥加密，主机密钥不存在时生成
+func Encrypt(installDir string, plain string) (string, error) {
+	keyFile := HostKeyFile(installDir)
+	key, err := readKey(keyFile)
+	if os.IsNotExist(err) {
+		key = make([]byte, hostKeySize)
+		if _, err = rand.Read(key); err != nil {
+			return "", err
+		}
+		if err = os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+			return "", err
+		}
+		err = utils.WriteFileAtomic(keyFile, []byte(hex.EncodeToString(key)), 0600)
+	}
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return PREFIX_ENC + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// EnsureFile 密钥文件不存在时生成随机密钥，替代原来固定的默认密码
+func EnsureFile(path string) error {
+	if _, err := os.Stat(path); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, []byte(hex.EncodeToString(buf)), 0600)
+}
+
+func readKey(keyFile string) ([]byte, error) {
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("host key %s permissions %#o are too open, must be 0600", keyFile, info.Mode().Perm())
+	}
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != hostKeySize {
+		return nil, fmt.Errorf("host key %s is invalid", keyFile)
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
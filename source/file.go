@@ -0,0 +1,103 @@
+package source
+
+import (
+	"bytes"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/userconfig"
+	"jrasp-daemon/zlog"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// 编辑器保存文件时会产生多个事件，合并后再读取
+const fileDebounce = 500 * time.Millisecond
+
+// FileSource 监听本地配置文件，适用于无法访问 nacos 的主机与测试
+type FileSource struct {
+	path    string
+	watcher *fsnotify.Watcher
+	last    []byte
+	done    chan struct{}
+	once    sync.Once
+}
+
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path, done: make(chan struct{})}
+}
+
+func (s *FileSource) Name() string {
+	return "file:" + s.path
+}
+
+// Start 读取一次文件并开始监听，监听所在目录以支持先写临时文件再 rename 的保存方式
+func (s *FileSource) Start(onChange func(data []byte) error) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(s.path)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+	s.watcher = watcher
+	s.load(onChange)
+	go s.loop(onChange)
+	return nil
+}
+
+func (s *FileSource) loop(onChange func(data []byte) error) {
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-s.done:
+			return
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(s.path) {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				timer = time.After(fileDebounce)
+			}
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			zlog.Warnf(defs.CONFIG_SOURCE, "[FileSource]", "watch %s,err:%v", s.path, err)
+		case <-timer:
+			timer = nil
+			s.load(onChange)
+		}
+	}
+}
+
+// load 内容没有变化时不触发热更新
+func (s *FileSource) load(onChange func(data []byte) error) {
+	data, err := userconfig.ReadConfigFile(s.path)
+	if err != nil {
+		zlog.Warnf(defs.CONFIG_SOURCE, "[FileSource]", "read %s,err:%v", s.path, err)
+		return
+	}
+	if bytes.Equal(data, s.last) {
+		return
+	}
+	s.last = data
+	zlog.Infof(defs.CONFIG_SOURCE, "[FileSource]", "config file changed:%s", s.path)
+	if err := onChange(data); err != nil {
+		zlog.Warnf(defs.CONFIG_SOURCE, "[FileSource]", "apply config,err:%v", err)
+	}
+}
+
+func (s *FileSource) Stop() {
+	s.once.Do(func() {
+		close(s.done)
+		if s.watcher != nil {
+			_ = s.watcher.Close()
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 等待时间大于 fileDebounce，保证合并后的读取已经完成
+const settle = 3 * fileDebounce
+
+type recorder chan string
+
+func (r recorder) onChange(data []byte) error {
+	r <- string(data)
+	return nil
+}
+
+func (r recorder) expect(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-r:
+		if got != want {
+			t.Fatalf("onChange got %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("onChange not called, want %q", want)
+	}
+}
+
+func (r recorder) expectNone(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-r:
+		t.Fatalf("unexpected onChange %q", got)
+	case <-time.After(settle):
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func startFileSource(t *testing.T, content string) (*FileSource, string, recorder) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "override.json")
+	writeFile(t, path, content)
+	s := NewFileSource(path)
+	r := make(recorder, 10)
+	if err := s.Start(r.onChange); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+	r.expect(t, content)
+	return s, path, r
+}
+
+func TestFileSourceWrite(t *testing.T) {
+	_, path, r := startFileSource(t, `{"logLevel":0}`)
+	writeFile(t, path, `{"logLevel":1}`)
+	r.expect(t, `{"logLevel":1}`)
+	r.expectNone(t)
+}
+
+func TestFileSourceRename(t *testing.T) {
+	_, path, r := startFileSource(t, `{"logLevel":0}`)
+	// 编辑器先写临时文件再 rename 覆盖
+	tmp := path + ".tmp"
+	writeFile(t, tmp, `{"logLevel":2}`)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	r.expect(t, `{"logLevel":2}`)
+	r.expectNone(t)
+}
+
+func TestFileSourceDebounce(t *testing.T) {
+	_, path, r := startFileSource(t, `{"logLevel":0}`)
+	for i := 1; i <= 5; i++ {
+		writeFile(t, path, `{"logLevel":`+string(rune('0'+i))+`}`)
+		time.Sleep(fileDebounce / 10)
+	}
+	r.expect(t, `{"logLevel":5}`)
+	r.expectNone(t)
+}
+
+func TestFileSourceUnchanged(t *testing.T) {
+	_, path, r := startFileSource(t, `{"logLevel":0}`)
+	writeFile(t, path, `{"logLevel":0}`)
+	r.expectNone(t)
+}
+
+func TestFileSourceYaml(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "override.yaml")
+	writeFile(t, path, "logLevel: 1\n")
+	s := NewFileSource(path)
+	r := make(recorder, 10)
+	if err := s.Start(r.onChange); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	r.expect(t, `{"logLevel":1}`)
+}
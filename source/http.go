@@ -0,0 +1,165 @@
+package source
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/userconfig"
+	"jrasp-daemon/zlog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	maxHttpConfigSize = 16 << 20
+	httpMinBackoff    = 5 * time.Second
+)
+
+// HttpSource 定时轮询 http 地址，使用 ETag 避免重复下载
+type HttpSource struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	etag     string
+	last     []byte // 不支持 ETag 的服务端按内容判断是否变化
+	done     chan struct{}
+	once     sync.Once
+
+	mu      sync.Mutex
+	lastErr error // 最近一次请求的错误
+}
+
+func NewHttpSource(url string, interval time.Duration) *HttpSource {
+	return &HttpSource{
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *HttpSource) Name() string {
+	return "http:" + s.url
+}
+
+func (s *HttpSource) Start(onChange func(data []byte) error) error {
+	s.poll(onChange)
+	go func() {
+		// 请求失败时按指数退避重试，最长不超过轮询间隔
+		backoff := httpMinBackoff
+		for {
+			wait := s.interval
+			if degraded, _ := s.Degraded(); !degraded {
+				backoff = httpMinBackoff
+			} else if backoff < s.interval {
+				wait = backoff
+				backoff *= 2
+			}
+			select {
+			case <-s.done:
+				return
+			case <-time.After(wait):
+				s.poll(onChange)
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *HttpSource) poll(onChange func(data []byte) error) {
+	data, changed, err := s.fetch()
+	s.mu.Lock()
+	s.lastErr = err
+	s.mu.Unlock()
+	if err != nil {
+		zlog.Warnf(defs.CONFIG_SOURCE, "[HttpSource]", "poll %s,err:%v", s.url, err)
+		return
+	}
+	if !changed {
+		return
+	}
+	zlog.Infof(defs.CONFIG_SOURCE, "[HttpSource]", "config changed,url:%s,etag:%s", s.url, s.etag)
+	if err := onChange(data); err != nil {
+		zlog.Warnf(defs.CONFIG_SOURCE, "[HttpSource]", "apply config,err:%v", err)
+	}
+}
+
+// fetch 返回 304 时 changed 为 false
+func (s *HttpSource) fetch() ([]byte, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, false, nil
+	case http.StatusOK:
+	default:
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil, false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHttpConfigSize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(body) > maxHttpConfigSize {
+		return nil, false, fmt.Errorf("config larger than %d bytes", maxHttpConfigSize)
+	}
+	data, err := userconfig.ToJSON(userconfig.FormatOf(s.formatName(resp), body), body)
+	if err != nil {
+		return nil, false, err
+	}
+	s.etag = resp.Header.Get("ETag")
+	if bytes.Equal(data, s.last) {
+		return nil, false, nil
+	}
+	s.last = data
+	return data, true, nil
+}
+
+// formatName 优先使用 Content-Type，其次使用 url 的扩展名
+func (s *HttpSource) formatName(resp *http.Response) string {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		return "config.json"
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return "config.yaml"
+	case "application/toml", "text/toml":
+		return "config.toml"
+	}
+	if u, err := url.Parse(s.url); err == nil {
+		return path.Base(u.Path)
+	}
+	return ""
+}
+
+// Degraded 最近一次请求失败时返回 true 与原因
+func (s *HttpSource) Degraded() (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastErr == nil {
+		return false, ""
+	}
+	return true, s.lastErr.Error()
+}
+
+func (s *HttpSource) Stop() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
@@ -0,0 +1,156 @@
+package source
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// configServer 按内容返回 ETag，If-None-Match 一致时返回 304
+type configServer struct {
+	mu          sync.Mutex
+	body        string
+	etag        string
+	contentType string
+	status      int
+	requests    int
+	notModified int
+}
+
+func (c *configServer) set(body, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.body, c.etag = body, etag
+}
+
+func (c *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	if c.status != 0 {
+		w.WriteHeader(c.status)
+		return
+	}
+	if c.etag != "" && r.Header.Get("If-None-Match") == c.etag {
+		c.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if c.etag != "" {
+		w.Header().Set("ETag", c.etag)
+	}
+	if c.contentType != "" {
+		w.Header().Set("Content-Type", c.contentType)
+	}
+	_, _ = w.Write([]byte(c.body))
+}
+
+func (r recorder) expectNow(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-r:
+		if got != want {
+			t.Fatalf("onChange got %q, want %q", got, want)
+		}
+	default:
+		t.Fatalf("onChange not called, want %q", want)
+	}
+}
+
+func (r recorder) expectNoneNow(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-r:
+		t.Fatalf("unexpected onChange %q", got)
+	default:
+	}
+}
+
+func TestHttpSourceETag(t *testing.T) {
+	cs := &configServer{}
+	cs.set(`{"logLevel":0}`, `"v1"`)
+	server := httptest.NewServer(cs)
+	defer server.Close()
+
+	s := NewHttpSource(server.URL+"/override.json", time.Minute)
+	r := make(recorder, 10)
+	s.poll(r.onChange)
+	r.expectNow(t, `{"logLevel":0}`)
+
+	// ETag 未变化时服务端返回 304，不触发热更新
+	s.poll(r.onChange)
+	r.expectNoneNow(t)
+	if cs.notModified != 1 {
+		t.Fatalf("notModified = %d, want 1", cs.notModified)
+	}
+
+	cs.set(`{"logLevel":1}`, `"v2"`)
+	s.poll(r.onChange)
+	r.expectNow(t, `{"logLevel":1}`)
+	if degraded, reason := s.Degraded(); degraded {
+		t.Fatalf("degraded: %s", reason)
+	}
+}
+
+func TestHttpSourceWithoutETag(t *testing.T) {
+	cs := &configServer{}
+	cs.set(`{"logLevel":0}`, "")
+	server := httptest.NewServer(cs)
+	defer server.Close()
+
+	s := NewHttpSource(server.URL+"/override.json", time.Minute)
+	r := make(recorder, 10)
+	s.poll(r.onChange)
+	r.expectNow(t, `{"logLevel":0}`)
+
+	// 不支持 ETag 的服务端按内容判断
+	s.poll(r.onChange)
+	r.expectNoneNow(t)
+	if cs.requests != 2 {
+		t.Fatalf("requests = %d, want 2", cs.requests)
+	}
+}
+
+func TestHttpSourceContentType(t *testing.T) {
+	cs := &configServer{contentType: "application/yaml"}
+	cs.set("logLevel: 1\n", `"v1"`)
+	server := httptest.NewServer(cs)
+	defer server.Close()
+
+	s := NewHttpSource(server.URL+"/config", time.Minute)
+	r := make(recorder, 10)
+	s.poll(r.onChange)
+	r.expectNow(t, `{"logLevel":1}`)
+}
+
+func TestHttpSourceDegraded(t *testing.T) {
+	cs := &configServer{}
+	cs.set(`{"logLevel":0}`, `"v1"`)
+	server := httptest.NewServer(cs)
+	defer server.Close()
+
+	s := NewHttpSource(server.URL+"/override.json", time.Minute)
+	r := make(recorder, 10)
+	s.poll(r.onChange)
+	r.expectNow(t, `{"logLevel":0}`)
+
+	cs.mu.Lock()
+	cs.status = http.StatusInternalServerError
+	cs.mu.Unlock()
+	s.poll(r.onChange)
+	r.expectNoneNow(t)
+	if degraded, _ := s.Degraded(); !degraded {
+		t.Fatal("want degraded after 500")
+	}
+
+	cs.mu.Lock()
+	cs.status = 0
+	cs.mu.Unlock()
+	s.poll(r.onChange)
+	r.expectNoneNow(t)
+	if degraded, reason := s.Degraded(); degraded {
+		t.Fatalf("still degraded: %s", reason)
+	}
+}
@@ -0,0 +1,48 @@
+package source
+
+import (
+	"fmt"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/nacos"
+	"jrasp-daemon/userconfig"
+	"path/filepath"
+	"time"
+)
+
+// ConfigSource 配置来源，配置变化时调用 onChange，内容已转换为 json
+// 所有来源使用同一个热更新流程(reload.Reloader.Reload)
+type ConfigSource interface {
+	Name() string
+	Start(onChange func(data []byte) error) error
+	Stop()
+}
+
+// Registry 同时作为注册中心的配置来源(nacos)，心跳时更新实例状态
+type Registry interface {
+	UpdateStatus(agentMode string, injectedJvms int, configVersion string)
+}
+
+// Health 可以检测控制面是否可达的配置来源，不可达时使用本地缓存的配置
+type Health interface {
+	Degraded() (bool, string)
+}
+
+// New 按配置创建配置来源，类型为 none 时返回 nil
+func New(cfg *userconfig.Config, env *environ.Environ) (ConfigSource, error) {
+	sc := cfg.ConfigSource
+	switch sc.Type {
+	case userconfig.SOURCE_NACOS:
+		return nacos.NewSource(cfg, env), nil
+	case userconfig.SOURCE_FILE:
+		path := sc.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(env.InstallDir, path)
+		}
+		return NewFileSource(path), nil
+	case userconfig.SOURCE_HTTP:
+		return NewHttpSource(sc.URL, time.Duration(sc.PollInterval)*time.Second), nil
+	case userconfig.SOURCE_NONE:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown config source %q", sc.Type)
+}
@@ -0,0 +1,310 @@
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"jrasp-daemon/artifact"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/utils"
+	"jrasp-daemon/zlog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxModuleVersions 每个模块保留的历史版本数量，用于回滚时不重新下载
+const MaxModuleVersions = 3
+
+// ModuleRecord 本地安装的一个模块版本
+type ModuleRecord struct {
+	Name        string `json:"name"`
+	Version     string `json:"version,omitempty"`
+	Digest      string `json:"digest"` // sha256:<hex>
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"modTime,omitempty"` // 文件的修改时间，unix 纳秒，与 size 都不变时不重新计算摘要
+	SourceURL   string `json:"sourceUrl"`
+	InstallTime string `json:"installTime"`
+}
+
+// ModuleManifest 保存在 required-module/manifest.json，用于判断模块是否需要更新
+type ModuleManifest struct {
+	Modules  map[string]ModuleRecord   `json:"modules"`  // 当前安装的模块
+	Previous map[string][]ModuleRecord `json:"previous"` // 历史版本，最新的在前，文件在 ModuleHistoryDir 下
+}
+
+// ModuleDir agent 加载模块的目录
+func ModuleDir(installDir string) string {
+	return filepath.Join(installDir, "required-module")
+}
+
+// ModuleManifestFile 本地模块清单
+func ModuleManifestFile(installDir string) string {
+	return filepath.Join(ModuleDir(installDir), "manifest.json")
+}
+
+// ModuleHistoryDir 历史版本的模块，不能放在 required-module 下，否则会被 agent 加载
+func ModuleHistoryDir(installDir string) string {
+	return filepath.Join(installDir, "data", "module-history")
+}
+
+func LoadModuleManifest(installDir string) (*ModuleManifest, error) {
+	manifest := &ModuleManifest{}
+	data, err := ioutil.ReadFile(ModuleManifestFile(installDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, manifest); err != nil {
+			return nil, err
+		}
+	}
+	if manifest.Modules == nil {
+		manifest.Modules = make(map[string]ModuleRecord)
+	}
+	if manifest.Previous == nil {
+		manifest.Previous = make(map[string][]ModuleRecord)
+	}
+	return manifest, nil
+}
+
+func (m *ModuleManifest) save(installDir string) error {
+	data, err := utils.MarshalIndent(m)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(ModuleManifestFile(installDir), data, 0600)
+}
+
+// moduleFileName 模块名称与文件名，兼容配置中带 .jar 后缀的名称
+func moduleFileName(name string) (string, string) {
+	name = strings.TrimSuffix(name, ".jar")
+	return name, name + ".jar"
+}
+
+// DownLoadModuleFiles 按清单判断模块是否需要更新，返回文件已替换的模块名称与版本，以及已删除的模块
+// 被替换或删除的模块保留到历史版本，期望的版本在历史版本中时直接恢复，不重新下载
+func (this *Update) DownLoadModuleFiles() (map[string]string, []string) {
+	installDir := this.env.InstallDir
+	manifest, err := LoadModuleManifest(installDir)
+	if err != nil {
+		// 清单损坏时重新建立，磁盘上摘要一致的模块不会重新下载
+		zlog.Errorf(defs.DOWNLOAD, "[Fix it] read module manifest failed", "file:%s,err:%v", ModuleManifestFile(installDir), err)
+		manifest = &ModuleManifest{Modules: make(map[string]ModuleRecord), Previous: make(map[string][]ModuleRecord)}
+	}
+	if err := os.MkdirAll(ModuleDir(installDir), 0700); err != nil {
+		zlog.Errorf(defs.DOWNLOAD, "create module dir failed", "err:%v", err)
+		return nil, nil
+	}
+
+	changed := make(map[string]string)
+	configured := make(map[string]bool)
+	dirty := false
+	for _, m := range this.cfg.ModuleConfigMap {
+		name, fileName := moduleFileName(m.ModuleName)
+		configured[name] = true
+		want, err := artifact.ParseDigest(m.Md5)
+		if err != nil {
+			zlog.Errorf(defs.DOWNLOAD, "[Fix it] bad module digest", "module:%s,err:%v", name, err)
+			continue
+		}
+		filePath := filepath.Join(ModuleDir(installDir), fileName)
+		record, installed := manifest.Modules[name]
+		if installed && want.Equal(record.Digest) && verifyInstalled(&record, want, filePath) {
+			record.Version, record.SourceURL = m.Version, m.DownLoadURL
+			if record != manifest.Modules[name] {
+				manifest.Modules[name] = record
+				dirty = true
+			}
+			continue
+		}
+		// 升级前没有清单，磁盘上的文件与期望一致时直接记录
+		if !installed {
+			if digest, err := artifact.FileDigest(filePath); err == nil && digest == want {
+				manifest.Modules[name] = newModuleRecord(name, m.Version, m.DownLoadURL, digest, filePath)
+				dirty = true
+				continue
+			}
+		}
+
+		tmpFileName := filepath.Join(ModuleDir(installDir), name+".tmp")
+		if !this.restoreModule(manifest, name, want, tmpFileName) {
+			// 下载，摘要与签名都校验通过后才替换
+			if _, err := this.fetch(m.DownLoadURL, tmpFileName, m.Md5, true); err != nil {
+				zlog.Errorf(defs.DOWNLOAD, "[BUG]verify module file failed", "tmpFileName:%s,err:%v", tmpFileName, err)
+				continue
+			}
+			zlog.Infof(defs.DOWNLOAD, "check file hash and signature success", "filePath:%s,hash:%s", tmpFileName, want)
+		}
+		if installed {
+			this.archiveModule(manifest, record, filePath)
+		}
+		if err := os.Rename(tmpFileName, filePath); err != nil {
+			zlog.Errorf(defs.DOWNLOAD, "[BUG]rename file name failed", "tmpFileName:%s,newFilePath:%s,err:%v", tmpFileName, filePath, err)
+			_ = os.Remove(tmpFileName)
+			continue
+		}
+		manifest.Modules[name] = newModuleRecord(name, m.Version, m.DownLoadURL, want, filePath)
+		dirty = true
+		changed[name] = m.Version
+		zlog.Infof(defs.DOWNLOAD, "install module success", "module:%s,version:%s,hash:%s", name, m.Version, want)
+	}
+
+	removed := this.removeModules(manifest, configured)
+	if dirty || len(removed) > 0 {
+		if err := manifest.save(installDir); err != nil {
+			zlog.Errorf(defs.DOWNLOAD, "write module manifest failed", "err:%v", err)
+		}
+	}
+	return changed, removed
+}
+
+// removeModules 删除不在配置中的模块(包括清单之外的 jar)，配置中没有任何模块时不清理，避免误删
+func (this *Update) removeModules(manifest *ModuleManifest, configured map[string]bool) []string {
+	if len(configured) == 0 {
+		return nil
+	}
+	files, err := ioutil.ReadDir(ModuleDir(this.env.InstallDir))
+	if err != nil {
+		zlog.Errorf(defs.DOWNLOAD, "list disk module file failed", "err:%v", err)
+		return nil
+	}
+	var removed []string
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".jar")
+		if file.IsDir() || name == file.Name() || configured[name] {
+			continue
+		}
+		filePath := filepath.Join(ModuleDir(this.env.InstallDir), file.Name())
+		record, ok := manifest.Modules[name]
+		if !ok {
+			digest, err := artifact.FileDigest(filePath)
+			if err != nil {
+				zlog.Errorf(defs.DOWNLOAD, "[Fix it] calc file hash error", "file:%s,err:%v", filePath, err)
+				continue
+			}
+			record = newModuleRecord(name, "", "", digest, filePath)
+		}
+		this.archiveModule(manifest, record, filePath)
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			zlog.Errorf(defs.DOWNLOAD, "remove module failed", "file:%s,err:%v", filePath, err)
+			continue
+		}
+		delete(manifest.Modules, name)
+		removed = append(removed, name)
+		zlog.Infof(defs.DOWNLOAD, "remove unconfigured module", "module:%s,version:%s,hash:%s", name, record.Version, record.Digest)
+	}
+	// 清单中有记录但文件已不存在的模块
+	for name := range manifest.Modules {
+		if !configured[name] {
+			delete(manifest.Modules, name)
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// archiveModule 把当前文件复制到历史版本，超过数量限制时删除最早的版本
+func (this *Update) archiveModule(manifest *ModuleManifest, record ModuleRecord, filePath string) {
+	digest, err := artifact.ParseDigest(record.Digest)
+	if err != nil {
+		return
+	}
+	for _, previous := range manifest.Previous[record.Name] {
+		if previous.Digest == record.Digest {
+			return
+		}
+	}
+	// 磁盘上的文件被修改过时不能作为该版本保留
+	if err := digest.Verify(filePath); err != nil {
+		zlog.Warnf(defs.DOWNLOAD, "skip archive modified module", "module:%s,err:%v", record.Name, err)
+		return
+	}
+	historyFile := this.historyFile(record.Name, digest)
+	if err := os.MkdirAll(filepath.Dir(historyFile), 0700); err != nil {
+		zlog.Warnf(defs.DOWNLOAD, "archive module failed", "module:%s,err:%v", record.Name, err)
+		return
+	}
+	if err := copyFileAtomic(filePath, historyFile, 0600); err != nil {
+		zlog.Warnf(defs.DOWNLOAD, "archive module failed", "module:%s,err:%v", record.Name, err)
+		return
+	}
+	versions := append([]ModuleRecord{record}, manifest.Previous[record.Name]...)
+	for len(versions) > MaxModuleVersions {
+		oldest := versions[len(versions)-1]
+		if d, err := artifact.ParseDigest(oldest.Digest); err == nil {
+			_ = os.Remove(this.historyFile(oldest.Name, d))
+		}
+		versions = versions[:len(versions)-1]
+	}
+	manifest.Previous[record.Name] = versions
+}
+
+// restoreModule 期望的版本在历史版本中并且摘要一致时复制到 tmpFileName
+// 历史版本在安装时已经校验过签名
+func (this *Update) restoreModule(manifest *ModuleManifest, name string, want artifact.Digest, tmpFileName string) bool {
+	for _, previous := range manifest.Previous[name] {
+		if !want.Equal(previous.Digest) {
+			continue
+		}
+		historyFile := this.historyFile(name, want)
+		if err := want.Verify(historyFile); err != nil {
+			zlog.Warnf(defs.DOWNLOAD, "module history broken", "file:%s,err:%v", historyFile, err)
+			return false
+		}
+		if err := copyFileAtomic(historyFile, tmpFileName, 0600); err != nil {
+			zlog.Warnf(defs.DOWNLOAD, "restore module failed", "file:%s,err:%v", historyFile, err)
+			return false
+		}
+		zlog.Infof(defs.DOWNLOAD, "restore module from history", "module:%s,version:%s,hash:%s", name, previous.Version, want)
+		return true
+	}
+	return false
+}
+
+func (this *Update) historyFile(name string, digest artifact.Digest) string {
+	return filepath.Join(ModuleHistoryDir(this.env.InstallDir), name, fmt.Sprintf("%s-%s.jar", name, digest.Hex[:12]))
+}
+
+func newModuleRecord(name, version, sourceURL string, digest artifact.Digest, filePath string) ModuleRecord {
+	size, modTime := fileStat(filePath)
+	return ModuleRecord{
+		Name:        name,
+		Version:     version,
+		Digest:      digest.String(),
+		Size:        size,
+		ModTime:     modTime,
+		SourceURL:   sourceURL,
+		InstallTime: time.Now().Format(defs.DATE_FORMAT),
+	}
+}
+
+// verifyInstalled 已安装的文件是否仍是清单记录的版本：大小与修改时间不变时直接使用记录，否则重新计算摘要
+// 摘要一致时更新 record 中的大小与修改时间
+func verifyInstalled(record *ModuleRecord, want artifact.Digest, filePath string) bool {
+	size, modTime := fileStat(filePath)
+	if size < 0 {
+		return false
+	}
+	if size == record.Size && modTime == record.ModTime {
+		return true
+	}
+	if err := want.Verify(filePath); err != nil {
+		zlog.Warnf(defs.DOWNLOAD, "module file modified on disk", "file:%s,err:%v", filePath, err)
+		return false
+	}
+	record.Size, record.ModTime = size, modTime
+	return true
+}
+
+// fileStat 文件的大小与修改时间，文件不存在时大小为 -1
+func fileStat(path string) (int64, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return -1, 0
+	}
+	return info.Size(), info.ModTime().UnixNano()
+}
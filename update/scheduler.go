@@ -0,0 +1,73 @@
+package update
+
+import (
+	"jrasp-daemon/defs"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/userconfig"
+	"jrasp-daemon/zlog"
+	"sync"
+	"time"
+)
+
+// ModuleRollout 模块文件替换或删除后通知运行中的JVM刷新
+type ModuleRollout interface {
+	RolloutModules(installed map[string]string, removed []string)
+}
+
+// Scheduler 定时检查可执行文件、模块与漏洞库的期望版本，与配置变更触发的检查串行执行
+type Scheduler struct {
+	env     *environ.Environ
+	mu      sync.Mutex
+	cfg     *userconfig.Config
+	rollout ModuleRollout
+	ticker  *time.Ticker
+}
+
+func NewScheduler(cfg *userconfig.Config, env *environ.Environ) *Scheduler {
+	return &Scheduler{
+		env:    env,
+		cfg:    cfg,
+		ticker: time.NewTicker(time.Minute * time.Duration(cfg.UpdateTicker)),
+	}
+}
+
+// SetRollout 注入协程启动后设置，之前替换的模块由注入时加载，不需要刷新
+func (s *Scheduler) SetRollout(rollout ModuleRollout) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollout = rollout
+}
+
+// Check 按 cfg 立即检查一次，配置变更时调用，cfg 作为之后定时检查的期望版本
+// 可执行文件更新成功后进程退出
+func (s *Scheduler) Check(cfg *userconfig.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cfg.UpdateTicker != s.cfg.UpdateTicker {
+		s.ticker.Reset(time.Minute * time.Duration(cfg.UpdateTicker))
+	}
+	s.cfg = cfg
+	s.check()
+}
+
+// Run 定时检查，模块文件可能被误删或替换，期望版本不变时也需要检查
+func (s *Scheduler) Run() {
+	zlog.Infof(defs.DOWNLOAD, "update check start...", "period:%d(min)", s.cfg.UpdateTicker)
+	for range s.ticker.C {
+		s.mu.Lock()
+		s.check()
+		s.mu.Unlock()
+	}
+}
+
+func (s *Scheduler) check() {
+	client := NewUpdateClient(s.cfg, s.env)
+	client.UpdateDaemonFile()
+	if changed, removed := client.DownLoadModuleFiles(); len(changed) > 0 || len(removed) > 0 {
+		zlog.Infof(defs.MODULE_ROLLOUT, "module files changed", "installed:%v,removed:%v", changed, removed)
+		if s.rollout != nil {
+			s.rollout.RolloutModules(changed, removed)
+		}
+	}
+	client.DownLoadVulnDb()
+}
@@ -1,140 +1,217 @@
 package update
 
 import (
+	"fmt"
 	"io/ioutil"
+	"jrasp-daemon/artifact"
 	"jrasp-daemon/defs"
 	"jrasp-daemon/environ"
+	"jrasp-daemon/history"
 	"jrasp-daemon/userconfig"
-	"jrasp-daemon/utils"
+	"jrasp-daemon/vuln"
 	"jrasp-daemon/zlog"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
-	"strings"
+	"time"
 )
 
-// TxOss 腾讯云对象存储
+// Update 可执行文件、模块与漏洞库的更新
 type Update struct {
-	cfg *userconfig.Config
-	env *environ.Environ
+	cfg      *userconfig.Config
+	env      *environ.Environ
+	verifier *artifact.Verifier // 安装包中固定的签名公钥
+	sources  *artifact.Sources  // 离线包、共享缓存与下载
 }
 
 func NewUpdateClient(cfg *userconfig.Config, env *environ.Environ) *Update {
+	verifier, err := artifact.LoadVerifier(env.InstallDir)
+	if err != nil {
+		// 公钥无法读取时拒绝全部需要签名的文件
+		zlog.Errorf(defs.DOWNLOAD, "[Fix it] load public keys failed", "dir:%s,err:%v", artifact.KeysDir(env.InstallDir), err)
+		verifier = &artifact.Verifier{}
+	}
 	return &Update{
-		cfg: cfg,
-		env: env,
+		cfg:      cfg,
+		env:      env,
+		verifier: verifier,
+		sources:  newSources(cfg.Download, env.InstallDir),
+	}
+}
+
+// newSources 配置已经校验过，代理、镜像与存储桶地址不会解析失败
+func newSources(cfg userconfig.DownloadConfig, installDir string) *artifact.Sources {
+	var proxy, mirror *url.URL
+	if cfg.Proxy != "" {
+		proxy, _ = url.Parse(cfg.Proxy)
+		if proxy != nil && cfg.ProxyUsername != "" {
+			proxy.User = url.UserPassword(cfg.ProxyUsername, cfg.ProxyPassword)
+		}
 	}
+	if cfg.Mirror != "" {
+		mirror, _ = url.Parse(cfg.Mirror)
+	}
+	downloader := artifact.NewDownloader(proxy, cfg.MaxSize<<20, cfg.MaxRetries, time.Duration(cfg.Timeout)*time.Second)
+	return artifact.NewSources(newBackend(downloader, cfg.Storage), cfg.MaxSize<<20, mirror, installPath(installDir, cfg.CacheDir), installPath(installDir, cfg.Bundle))
 }
 
-// DownLoad
-// url 文件下载链接
-// filePath 下载的绝对路径
-func (this *Update) DownLoad(url, filePath string) error {
-	err := utils.DownLoadFile(url, filePath)
+// newBackend 密钥在加载配置时已经从密钥存储解析为明文，轮换后下一次检查使用新的凭证
+func newBackend(downloader *artifact.Downloader, cfg userconfig.StorageConfig) artifact.Backend {
+	endpoint, err := url.Parse(cfg.Endpoint)
 	if err != nil {
-		return err
+		return downloader
 	}
-	return nil
+	switch cfg.Type {
+	case userconfig.STORAGE_COS:
+		return artifact.NewCOSBackend(downloader, endpoint, cfg.AccessKey, cfg.SecretKey, cfg.SessionToken)
+	case userconfig.STORAGE_S3:
+		return artifact.NewS3Backend(downloader, endpoint, cfg.Region, cfg.AccessKey, cfg.SecretKey, cfg.SessionToken)
+	}
+	return downloader
+}
+
+// installPath 相对路径基于安装目录
+func installPath(installDir, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(installDir, p)
+}
+
+// fetch 从离线包、共享缓存或下载链接获取到 tmpFilePath 并校验摘要，signed 为 true 时同时校验 <url>.sig 签名
+// 校验失败时删除临时文件，调用方只在返回 nil 后替换正式文件；下载的文件校验通过后放入共享缓存
+func (this *Update) fetch(url, tmpFilePath, digest string, signed bool) (artifact.Digest, error) {
+	want, err := artifact.ParseDigest(digest)
+	if err != nil {
+		return want, err
+	}
+	sigFilePath := tmpFilePath + artifact.SignatureSuffix
+	defer os.Remove(sigFilePath)
+	source, err := this.sources.Fetch(url, want, tmpFilePath, signed)
+	if err != nil {
+		_ = os.Remove(tmpFilePath)
+		return want, err
+	}
+	if signed {
+		signature, err := ioutil.ReadFile(sigFilePath)
+		if err == nil {
+			err = this.verifier.Verify(tmpFilePath, signature)
+		}
+		if err != nil {
+			_ = os.Remove(tmpFilePath)
+			return want, fmt.Errorf("%s: %v", source, err)
+		}
+	}
+	if source == artifact.SOURCE_REMOTE {
+		this.sources.Store(want, tmpFilePath, signed)
+	}
+	return want, nil
 }
 
 // UpdateDaemonFile 更新守护进程
+// 新文件校验通过后备份当前文件并原子替换，进程退出后由 systemd 拉起新版本，新版本在期限内未报告健康时恢复备份
 func (this *Update) UpdateDaemonFile() {
 	// 配置中可执行文件hash不为空，并且与env中可执行文件hash不相同
-	if this.cfg.ExeOssFileHash != "" && this.cfg.ExeOssFileHash != this.env.ExeFileHash {
-		newFilePath := filepath.Join(this.env.InstallDir, "bin", "jrasp-daemon.tmp")
-		_ = this.DownLoad(this.cfg.ExeOssFileName, newFilePath)
-		newHash, err := utils.GetFileHash(newFilePath)
-		if err != nil {
-			zlog.Errorf(defs.DOWNLOAD, "download  jrasp-daemon file", "err:%v", err)
-		} else {
-			// 校验下载文件的hash
-			if newHash == this.cfg.ExeOssFileHash {
-				this.replace()
-			} else {
-				zlog.Errorf(defs.DOWNLOAD, "[BUG]check new file hash err", "newFileHash:%s,configHash:%s", newHash, this.cfg.ExeOssFileHash)
-				err := os.Remove(newFilePath)
-				if err != nil {
-					zlog.Errorf(defs.DOWNLOAD, "[BUG]delete broken file err", "newFileHash:%s", newHash)
-				}
-			}
-		}
-	} else {
+	if this.cfg.ExeOssFileHash == "" {
 		zlog.Infof(defs.DOWNLOAD, "no need to update jrasp-daemon", "userconfig.ExecOssFileHash:%s,env.ExecDiskFileHash:%s", this.cfg.ExeOssFileHash, this.env.ExeFileHash)
+		return
+	}
+	want, err := artifact.ParseDigest(this.cfg.ExeOssFileHash)
+	if err != nil {
+		zlog.Errorf(defs.DOWNLOAD, "[Fix it] bad exeOssFileHash", "err:%v", err)
+		return
 	}
+	if want.Equal(this.env.ExeFileHash) {
+		zlog.Infof(defs.DOWNLOAD, "no need to update jrasp-daemon", "userconfig.ExecOssFileHash:%s,env.ExecDiskFileHash:%s", this.cfg.ExeOssFileHash, this.env.ExeFileHash)
+		return
+	}
+	if state, err := loadUpgradeState(this.env.InstallDir); err == nil && state.Status == UPGRADE_FAILED && want.Equal(state.ToHash) {
+		zlog.Warnf(defs.SELF_UPDATE, "skip failed version", "hash:%s,reason:%s", state.ToHash, state.Reason)
+		return
+	}
+	newFilePath := ExePath(this.env.InstallDir) + ".tmp"
+	if _, err := this.fetch(this.cfg.ExeOssFileName, newFilePath, this.cfg.ExeOssFileHash, true); err != nil {
+		zlog.Errorf(defs.DOWNLOAD, "[BUG]verify new jrasp-daemon file err", "configHash:%s,err:%v", this.cfg.ExeOssFileHash, err)
+		return
+	}
+	if err := this.replace(newFilePath, want.String()); err != nil {
+		zlog.Errorf(defs.SELF_UPDATE, "[BUG]replace jrasp-daemon file error", "jrasp-daemon.tmp file will delete,err:%v", err)
+		_ = os.Remove(newFilePath)
+		return
+	}
+	zlog.Infof(defs.SELF_UPDATE, "update jrasp-daemon file success", "fromVersion:%s,hash:%s,daemon process will exit...", defs.JRASP_DAEMON_VERSION, want)
+	history.MarkCleanExit(this.env.InstallDir)
+	os.Exit(0) // 进程退出
 }
 
-// replace 文件rename
-func (this *Update) replace() {
+// replace 备份当前文件，记录升级状态后原子替换，均使用绝对路径
+func (this *Update) replace(newFilePath, newHash string) error {
+	exePath := ExePath(this.env.InstallDir)
 	// 增加可执行权限
-	err := os.Chmod("jrasp-daemon.tmp", 0700)
-	if err != nil {
-		zlog.Infof(defs.DOWNLOAD, "chmod +x jrasp-demon.tmp", "err:%v", err)
-	}
-	err = os.Rename("jrasp-daemon.tmp", "jrasp-daemon")
-	if err == nil {
-		zlog.Infof(defs.DOWNLOAD, "update jrasp-daemon file success", "rename jrasp-daemon file success,daemon process will exit...")
-		// 再次check
-		success, _ := utils.PathExists("jrasp-daemon")
-		if success {
-			os.Exit(0) // 进程退出
-		}
-	} else {
-		zlog.Errorf(defs.DOWNLOAD, "[BUG]rename jrasp-daemon file error", "jrasp-daemon file will delete")
-		_ = os.Remove("jrasp-daemon.tmp")
+	if err := os.Chmod(newFilePath, 0700); err != nil {
+		return err
+	}
+	if err := copyFileAtomic(exePath, BackupPath(this.env.InstallDir), 0700); err != nil {
+		return fmt.Errorf("backup %s: %v", exePath, err)
+	}
+	now := time.Now()
+	state := &UpgradeState{
+		FromVersion: defs.JRASP_DAEMON_VERSION,
+		FromHash:    this.env.ExeFileHash,
+		ToHash:      newHash,
+		Status:      UPGRADE_PENDING,
+		Time:        now.Format(defs.DATE_FORMAT),
+		Deadline:    now.Add(UpgradeHealthDeadline).Unix(),
+	}
+	if err := saveUpgradeState(this.env.InstallDir, state); err != nil {
+		return err
+	}
+	// 同目录下 rename 是原子的，任意时刻 exePath 都是完整的文件
+	if err := os.Rename(newFilePath, exePath); err != nil {
+		_ = os.Remove(UpgradeStateFile(this.env.InstallDir))
+		return err
 	}
+	return nil
 }
 
-// DownLoadModuleFiles 模块升级
-func (this *Update) DownLoadModuleFiles() {
-	// 获取磁盘上的插件
-	files, err := ioutil.ReadDir(filepath.Join(this.env.InstallDir, "required-module"))
+// DownLoadVulnDb 漏洞库更新，只校验摘要
+func (this *Update) DownLoadVulnDb() {
+	dbCfg := this.cfg.VulnDbConfig
+	if dbCfg.DownLoadURL == "" {
+		return
+	}
+	u, err := url.Parse(dbCfg.DownLoadURL)
+	if err != nil || path.Base(u.Path) == "" || path.Base(u.Path) == "/" {
+		zlog.Errorf(defs.DOWNLOAD, "[Fix it] bad vuln db url", "url:%s,err:%v", dbCfg.DownLoadURL, err)
+		return
+	}
+	dbDir := vuln.DatabaseDir(this.env.InstallDir)
+	if err := os.MkdirAll(dbDir, 0700); err != nil {
+		zlog.Errorf(defs.DOWNLOAD, "create vuln db dir failed", "dir:%s,err:%v", dbDir, err)
+		return
+	}
+	dbFilePath := filepath.Join(dbDir, path.Base(u.Path))
+	if digest, err := artifact.FileDigest(dbFilePath); err == nil && sameDigest(dbCfg.Md5, digest.String()) {
+		zlog.Infof(defs.DOWNLOAD, "no need to update vuln db", "filePath:%s,hash:%s", dbFilePath, digest)
+		return
+	}
+	tmpFileName := dbFilePath + ".tmp"
+	digest, err := this.fetch(dbCfg.DownLoadURL, tmpFileName, dbCfg.Md5, false)
 	if err != nil {
-		zlog.Errorf(defs.DOWNLOAD, "list disk module file failed", "err:%v", err)
+		zlog.Errorf(defs.DOWNLOAD, "[BUG]check vuln db hash failed", "filePath:%s,configHash:%s,err:%v", tmpFileName, dbCfg.Md5, err)
 		return
 	}
-
-	// 1.先检测磁盘上的全部插件的名称、hash
-	var fileHashMap = make(map[string]string)
-	for _, file := range files {
-		name := file.Name()
-		if !file.IsDir() && strings.HasSuffix(name, ".jar") {
-			hash, err := utils.GetFileHash(filepath.Join(this.env.InstallDir, "required-module", name))
-			if err != nil {
-				zlog.Errorf(defs.DOWNLOAD, "[Fix it] calc file hash error", "file:%s,err:%v", name, err)
-			} else {
-				fileHashMap[name] = hash
-			}
-		}
+	if err := os.Rename(tmpFileName, dbFilePath); err != nil {
+		zlog.Errorf(defs.DOWNLOAD, "[BUG]rename file name failed", "tmpFileName:%s,newFilePath:%s,err:%v", tmpFileName, dbFilePath, err)
+		_ = os.Remove(tmpFileName)
+		return
 	}
+	zlog.Infof(defs.DOWNLOAD, "update vuln db success", "filePath:%s,hash:%s", dbFilePath, digest)
+}
 
-	// 2.下载
-	for _, m := range this.cfg.ModuleConfigMap {
-		hash, ok := fileHashMap[m.ModuleName]
-		if !ok || hash != m.Md5 {
-			// 下载
-			tmpFileName := filepath.Join(this.env.InstallDir, "required-module", m.ModuleName+".tmp")
-			err := utils.DownLoadFile(m.DownLoadURL, tmpFileName) // module.jar.tmp
-			if err != nil {
-				zlog.Errorf(defs.DOWNLOAD, "[BUG]download file failed", "tmpFileName:%s,err:%v", tmpFileName, err)
-				continue
-			}
-			// hash 校验
-			diskFileHash, err := utils.GetFileHash(tmpFileName)
-			if err != nil {
-				zlog.Errorf(defs.DOWNLOAD, "[BUG]cal file hash failed", "filePath:%s,err:%v", tmpFileName, err)
-				_ = os.Remove(tmpFileName)
-				continue
-			}
-			// 校验成功，修改名称
-			if diskFileHash == m.Md5 {
-				zlog.Infof(defs.DOWNLOAD, "check file hash success", "filePath:%s,hash:%v", tmpFileName, diskFileHash)
-				newFilePath := filepath.Join(this.env.InstallDir, "required-module", m.ModuleName+".jar")
-				err := os.Rename(tmpFileName, newFilePath)
-				if err != nil {
-					zlog.Errorf(defs.DOWNLOAD, "[BUG]rename file name failed", "tmpFileName:%s,newFilePath:%s,err:%v", tmpFileName, newFilePath, err)
-					_ = os.Remove(tmpFileName)
-					continue
-				}
-			}
-		}
-	}
+// sameDigest 配置中的摘要与磁盘文件的摘要是否相同，配置不合法时视为不同
+func sameDigest(configDigest, diskDigest string) bool {
+	want, err := artifact.ParseDigest(configDigest)
+	return err == nil && want.Equal(diskDigest)
 }
@@ -0,0 +1,135 @@
+package update
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"jrasp-daemon/artifact"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const moduleContent = "module content"
+
+func digestOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// newTestUpdate 固定 pinned 公钥，制品与签名由 files 提供，不存在的路径返回 404
+func newTestUpdate(t *testing.T, pinned ed25519.PublicKey, files map[string][]byte) (*Update, string, string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+
+	installDir := t.TempDir()
+	keysDir := artifact.KeysDir(installDir)
+	if err := os.MkdirAll(keysDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(pinned)
+	if err := ioutil.WriteFile(filepath.Join(keysDir, "release.pub"), []byte(key), 0644); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := artifact.LoadVerifier(installDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := filepath.Join(installDir, "cache")
+	downloader := artifact.NewDownloader(nil, 1<<20, 0, 10*time.Second)
+	return &Update{
+		verifier: verifier,
+		sources:  artifact.NewSources(downloader, 1<<20, nil, cacheDir, ""),
+	}, server.URL, cacheDir
+}
+
+func TestFetch(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	files := map[string][]byte{
+		"/signed.jar":       []byte(moduleContent),
+		"/signed.jar.sig":   ed25519.Sign(priv, []byte(moduleContent)),
+		"/forged.jar":       []byte(moduleContent),
+		"/forged.jar.sig":   ed25519.Sign(otherPriv, []byte(moduleContent)),
+		"/unsigned.jar":     []byte(moduleContent),
+		"/garbage.jar":      []byte(moduleContent),
+		"/garbage.jar.sig":  []byte("not a signature"),
+		"/vuln-db.zip":      []byte(moduleContent),
+		"/tampered.jar":     []byte("tampered"),
+		"/tampered.jar.sig": ed25519.Sign(priv, []byte("tampered")),
+	}
+	cases := []struct {
+		name    string
+		path    string
+		signed  bool
+		wantErr string
+	}{
+		{name: "signed", path: "/signed.jar", signed: true},
+		{name: "unsigned not required", path: "/vuln-db.zip"},
+		{name: "signed by other key", path: "/forged.jar", signed: true, wantErr: "signature verification failed"},
+		{name: "signature missing", path: "/unsigned.jar", signed: true, wantErr: "download signature"},
+		{name: "bad signature", path: "/garbage.jar", signed: true, wantErr: "bad ed25519 signature"},
+		{name: "digest mismatch", path: "/tampered.jar", signed: true, wantErr: "digest mismatch"},
+	}
+	for _, c := range cases {
+		u, baseURL, cacheDir := newTestUpdate(t, pub, files)
+		tmpFilePath := filepath.Join(t.TempDir(), "module.jar.tmp")
+		want, err := u.fetch(baseURL+c.path, tmpFilePath, digestOf(moduleContent), c.signed)
+		if _, statErr := os.Stat(tmpFilePath + artifact.SignatureSuffix); !os.IsNotExist(statErr) {
+			t.Errorf("%s: signature file left behind", c.name)
+		}
+		cacheFile := filepath.Join(cacheDir, want.Algorithm, want.Hex)
+		if c.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+				continue
+			}
+			if data, _ := ioutil.ReadFile(tmpFilePath); string(data) != moduleContent {
+				t.Errorf("%s: content = %q", c.name, data)
+			}
+			if _, err := os.Stat(cacheFile); err != nil {
+				t.Errorf("%s: not stored in cache: %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.wantErr)
+		}
+		// 校验失败的文件不能留在临时路径或共享缓存，调用方不会替换正式文件
+		if _, statErr := os.Stat(tmpFilePath); !os.IsNotExist(statErr) {
+			t.Errorf("%s: temp file not removed", c.name)
+		}
+		if _, statErr := os.Stat(cacheFile); !os.IsNotExist(statErr) {
+			t.Errorf("%s: stored in cache", c.name)
+		}
+	}
+}
+
+func TestFetchBadDigest(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	u, baseURL, _ := newTestUpdate(t, pub, map[string][]byte{"/signed.jar": []byte(moduleContent)})
+	tmpFilePath := filepath.Join(t.TempDir(), "module.jar.tmp")
+	for _, digest := range []string{"", "5d41402abc4b2a76b9719d911017c592", "md5:5d41402abc4b2a76b9719d911017c592"} {
+		if _, err := u.fetch(baseURL+"/signed.jar", tmpFilePath, digest, true); err == nil {
+			t.Errorf("digest %q: want error", digest)
+		}
+		if _, err := os.Stat(tmpFilePath); !os.IsNotExist(err) {
+			t.Errorf("digest %q: downloaded without a valid digest", digest)
+		}
+	}
+}
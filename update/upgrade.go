@@ -0,0 +1,232 @@
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/environ"
+	"jrasp-daemon/utils"
+	"jrasp-daemon/zlog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// UpgradeStatus 自升级的状态
+type UpgradeStatus string
+
+const (
+	UPGRADE_PENDING UpgradeStatus = "pending" // 已替换，等待新版本报告健康
+	UPGRADE_GOOD    UpgradeStatus = "good"    // 新版本在期限内报告健康
+	UPGRADE_FAILED  UpgradeStatus = "failed"  // 新版本启动失败或超时，已恢复备份
+)
+
+const (
+	MaxUpgradeStartAttempts = 3                // 新版本连续启动的最大次数，超过视为启动失败
+	UpgradeHealthDeadline   = 5 * time.Minute  // 新版本需要在替换后的期限内报告健康
+	upgradeStableTime       = 60 * time.Second // 初始化完成后稳定运行的时间，之后视为健康
+)
+
+// UpgradeState 保存在 InstallDir/data/upgrade.json，启动脚本也会读取 status 与 deadline，并在 data/upgrade.starts 中单独计数启动次数
+type UpgradeState struct {
+	FromVersion   string        `json:"fromVersion"`
+	FromHash      string        `json:"fromHash"`
+	ToHash        string        `json:"toHash"`
+	Status        UpgradeStatus `json:"status"`
+	Time          string        `json:"time"`
+	Deadline      int64         `json:"deadline"` // unix 秒
+	StartAttempts int           `json:"startAttempts,omitempty"`
+	Reason        string        `json:"reason,omitempty"`
+	Reported      bool          `json:"reported,omitempty"`
+}
+
+// UpgradeFailedEvent 升级失败事件，由恢复后的旧版本上报
+type UpgradeFailedEvent struct {
+	FromVersion string `json:"fromVersion"`
+	FailedHash  string `json:"failedHash"`
+	Reason      string `json:"reason"`
+}
+
+// ExePath 可执行文件的绝对路径
+func ExePath(installDir string) string {
+	return filepath.Join(installDir, "bin", "jrasp-daemon")
+}
+
+// BackupPath 上一个版本的可执行文件
+func BackupPath(installDir string) string {
+	return ExePath(installDir) + ".bak"
+}
+
+// UpgradeStateFile 自升级状态文件
+func UpgradeStateFile(installDir string) string {
+	return filepath.Join(installDir, "data", "upgrade.json")
+}
+
+// Guard 启动时检查上一次自升级的结果，新版本在期限内未报告健康时恢复备份并退出，由 systemd 重新拉起旧版本
+type Guard struct {
+	env      *environ.Environ
+	mu       sync.Mutex
+	state    *UpgradeState
+	watchdog *time.Timer
+}
+
+func NewGuard(env *environ.Environ) *Guard {
+	return &Guard{env: env}
+}
+
+// Check 启动时调用，返回需要上报的升级失败事件
+// 观察中的新版本启动次数超过限制或已超过期限时恢复备份并退出进程
+func (g *Guard) Check() *UpgradeFailedEvent {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	state, err := loadUpgradeState(g.env.InstallDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			zlog.Warnf(defs.SELF_UPDATE, "read upgrade state failed", "err:%v", err)
+		}
+		return nil
+	}
+	g.state = state
+	switch state.Status {
+	case UPGRADE_PENDING:
+		if g.env.ExeFileHash != state.ToHash {
+			// 运行的不是新版本，说明启动脚本已经恢复了备份
+			g.fail("new version not healthy before deadline, restored by startup script")
+			break
+		}
+		state.StartAttempts++
+		remaining := time.Until(time.Unix(state.Deadline, 0))
+		if state.StartAttempts > MaxUpgradeStartAttempts {
+			g.restoreAndExit(fmt.Sprintf("new version failed to start %d times", MaxUpgradeStartAttempts))
+			break
+		}
+		if remaining <= 0 {
+			g.restoreAndExit("new version not healthy before deadline")
+			break
+		}
+		g.save()
+		zlog.Infof(defs.SELF_UPDATE, "new version started", "fromVersion:%s,hash:%s,attempt:%d,deadline:%s", state.FromVersion, state.ToHash, state.StartAttempts, remaining)
+		// 初始化阻塞或健康检查不通过时，由看门狗在期限到达时恢复
+		g.watchdog = time.AfterFunc(remaining, func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.state.Status == UPGRADE_PENDING {
+				g.restoreAndExit("new version not healthy before deadline")
+			}
+		})
+	}
+	if state.Status == UPGRADE_FAILED && !state.Reported {
+		state.Reported = true
+		g.save()
+		event := &UpgradeFailedEvent{FromVersion: state.FromVersion, FailedHash: state.ToHash, Reason: state.Reason}
+		zlog.Errorf(defs.SELF_UPDATE, "daemon upgrade failed", utils.ToString(event))
+		return event
+	}
+	return nil
+}
+
+// MonitorHealth 初始化完成后调用，稳定运行一段时间后标记新版本健康
+func (g *Guard) MonitorHealth() {
+	g.mu.Lock()
+	pending := g.state != nil && g.state.Status == UPGRADE_PENDING
+	g.mu.Unlock()
+	if !pending {
+		return
+	}
+	time.Sleep(upgradeStableTime)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.state.Status != UPGRADE_PENDING {
+		return
+	}
+	g.watchdog.Stop()
+	g.state.Status = UPGRADE_GOOD
+	g.state.StartAttempts = 0
+	g.save()
+	zlog.Infof(defs.SELF_UPDATE, "new version is healthy", "fromVersion:%s,version:%s,hash:%s", g.state.FromVersion, defs.JRASP_DAEMON_VERSION, g.state.ToHash)
+}
+
+// restoreAndExit 恢复备份并退出，调用方持有锁
+func (g *Guard) restoreAndExit(reason string) {
+	exePath, backupPath := ExePath(g.env.InstallDir), BackupPath(g.env.InstallDir)
+	if err := os.Rename(backupPath, exePath); err != nil {
+		// 没有可用的备份时继续运行新版本，避免进程反复退出
+		zlog.Errorf(defs.SELF_UPDATE, "[BUG]restore backup failed", "backup:%s,reason:%s,err:%v", backupPath, reason, err)
+		g.fail(reason + ", restore backup failed: " + err.Error())
+		return
+	}
+	g.fail(reason)
+	zlog.Errorf(defs.SELF_UPDATE, "restore backup", "file:%s,reason:%s,daemon process will exit...", exePath, reason)
+	os.Exit(1)
+}
+
+func (g *Guard) fail(reason string) {
+	g.state.Status = UPGRADE_FAILED
+	g.state.Reason = reason
+	g.save()
+}
+
+func (g *Guard) save() {
+	if err := saveUpgradeState(g.env.InstallDir, g.state); err != nil {
+		zlog.Warnf(defs.SELF_UPDATE, "write upgrade state failed", "err:%v", err)
+	}
+}
+
+func loadUpgradeState(installDir string) (*UpgradeState, error) {
+	data, err := ioutil.ReadFile(UpgradeStateFile(installDir))
+	if err != nil {
+		return nil, err
+	}
+	state := &UpgradeState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func saveUpgradeState(installDir string, state *UpgradeState) error {
+	file := UpgradeStateFile(installDir)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(file, data, 0600)
+}
+
+// copyFileAtomic 复制文件，先写临时文件再rename
+func copyFileAtomic(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		_ = os.Remove(tmpName)
+	}()
+	if _, err = io.Copy(tmp, in); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, dst)
+}
@@ -1,6 +1,8 @@
 package userconfig
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/spf13/viper"
 )
@@ -25,7 +27,7 @@ type Config struct {
 	Namespace  string `json:"namespace"`
 	EnableAuth bool   `json:"enableAuth"`
 	Username   string `json:"username"`
-	Password   string `json:"password"`
+	Password   string `json:"password" secret:"true"` // 密钥引用，加载后为明文
 
 	// 日志配置
 	LogLevel int    `json:"logLevel"`
@@ -42,73 +44,202 @@ type Config struct {
 	ProcessInjectTicker   uint32 `json:"processInjectTicker"`
 	HeartBeatReportTicker uint   `json:"heartBeatReportTicker"`
 	DependencyTicker      uint32 `json:"dependencyTicker"`
+	UpdateTicker          uint32 `json:"updateTicker"` // 检查可执行文件、模块与漏洞库更新的周期，分钟
+
+	// 配置来源：nacos、本地文件或 http 轮询
+	ConfigSource ConfigSourceConfig `json:"configSource"`
 
 	// nacos 配置
-	NamespaceId string   `json:"namespaceId"` // 命名空间
-	DataId      string   `json:"dataId"`      // 配置id
-	IpAddrs     []string `json:"ipAddrs"`     // nacos 服务端ip列表
+	NamespaceId string      `json:"namespaceId"` // 命名空间
+	DataId      string      `json:"dataId"`      // 配置id
+	IpAddrs     []string    `json:"ipAddrs"`     // nacos 服务端ip列表
+	Nacos       NacosConfig `json:"nacos"`       // nacos 连接与认证参数
+
+	// 远程命令通道：nacos 或 http 轮询
+	Command CommandConfig `json:"command"`
+
+	// 心跳、进程、依赖上报到 collector
+	Report ReportConfig `json:"report"`
+
+	// 可执行文件、模块与漏洞库的下载参数
+	Download DownloadConfig `json:"download"`
 
 	// jrasp-daemon 自身配置
 	ExeOssFileName string `json:"exeOssFileName"` // 相对于bucketURLStr的路径
-	ExeOssFileHash string `json:"exeOssFileHash"` // 可执行文件的hash
+	ExeOssFileHash string `json:"exeOssFileHash"` // 可执行文件的摘要，sha256:<hex>
 
 	// module列表
 	ModuleConfigMap map[string]ModuleConfig `json:"moduleConfigMap"` // 模块配置消息
+
+	// 按应用覆盖运行模式、模块与参数
+	Overlays []Overlay `json:"overlays"`
+
+	// 漏洞库，与模块一样由daemon下载到本地
+	VulnDbConfig VulnDbConfig `json:"vulnDbConfig"`
 }
 
 // ModuleConfig module信息
 type ModuleConfig struct {
-	ModuleName  string            `json:"moduleName"`  // 名称，如tomcat.jar
+	ModuleName  string            `json:"moduleName"`  // 名称，如rce-hook，对应 required-module/rce-hook.jar
 	RouterPath  string            `json:"routerPath"`  // 参数路由路径
 	ModuleType  string            `json:"moduleType"`  // 模块类型：hook、algorithm
 	DownLoadURL string            `json:"downLoadURL"` // 下载链接
-	Md5         string            `json:"md5"`         // 插件hash
+	Md5         string            `json:"md5"`         // 插件摘要，sha256:<hex>，字段名为兼容保留
+	Version     string            `json:"version"`     // 模块版本，不为空时刷新后确认 agent 加载的是该版本
 	Parameters  map[string]string `json:"parameters"`  // 参数列表
 }
 
-func InitConfig() (*Config, error) {
-	var (
-		v   *viper.Viper
-		err error
-		c   Config
-	)
+// 配置来源类型
+const (
+	SOURCE_NACOS = "nacos" // nacos 配置中心
+	SOURCE_FILE  = "file"  // 监听本地文件
+	SOURCE_HTTP  = "http"  // http 轮询
+	SOURCE_NONE  = "none"  // 只使用本地配置(未配置 ipAddrs 与 dataId 时的默认值)
+)
 
-	v = viper.New()
-	v.SetConfigName("config") // 文件名称
-	v.SetConfigType("json")   // 文件类型
+// ConfigSourceConfig 配置来源，下发的配置与其他来源合并，优先级最高
+type ConfigSourceConfig struct {
+	Type         string `json:"type"`         // nacos、file、http、none
+	Path         string `json:"path"`         // file: 监听的文件，相对路径基于安装目录
+	URL          string `json:"url"`          // http: 配置地址
+	PollInterval uint32 `json:"pollInterval"` // http: 轮询间隔，秒
+}
 
-	// 安装目录下的cfg
-	v.AddConfigPath("../cfg")
-	v.AddConfigPath("./cfg")
+// CommandConfig 远程命令通道，默认关闭
+type CommandConfig struct {
+	Type         string   `json:"type"`         // nacos、http、none
+	DataId       string   `json:"dataId"`       // nacos: 命令 dataId，为空时使用 <主机名>-command，结果发布到 <dataId>-result
+	URL          string   `json:"url"`          // http: 命令地址
+	ResultURL    string   `json:"resultUrl"`    // http: 结果上报地址(POST)
+	PollInterval uint32   `json:"pollInterval"` // http: 轮询间隔，秒
+	UploadURLs   []string `json:"uploadUrls"`   // upload-diagnostics 允许的上传地址前缀，为空时不允许上传
+}
 
-	setDefaultValue(v) // 设置系统默认值
-	// 读取配置文件值，并覆盖系统默尔值
-	if err = v.ReadInConfig(); err != nil {
-		fmt.Print("use default config,can not read config file")
-	}
+// DownloadConfig 下载参数，下载时读取，修改后不需要重启
+type DownloadConfig struct {
+	Proxy         string        `json:"proxy"`                       // 代理地址，为空时使用 HTTPS_PROXY/HTTP_PROXY/NO_PROXY 环境变量
+	ProxyUsername string        `json:"proxyUsername"`               // 代理认证用户名
+	ProxyPassword string        `json:"proxyPassword" secret:"true"` // 代理认证密码，密钥引用(file:/env:/enc:)
+	MaxSize       int64         `json:"maxSize"`                     // 单个文件的最大长度，MB
+	MaxRetries    int           `json:"maxRetries"`                  // 网络错误、5xx、429 的重试次数，重试时断点续传
+	Timeout       uint32        `json:"timeout"`                     // 单次请求的超时，秒
+	Mirror        string        `json:"mirror"`                      // 内网镜像地址(http/https/file)，下载链接改为 <mirror>/<原路径>
+	CacheDir      string        `json:"cacheDir"`                    // 多个安装共享的缓存目录，相对路径基于安装目录
+	Bundle        string        `json:"bundle"`                      // 离线包目录，按下载链接的文件名查找，相对路径基于安装目录
+	Storage       StorageConfig `json:"storage"`                     // 私有存储桶
+}
+
+// 私有存储桶类型
+const (
+	STORAGE_COS = "cos" // 腾讯云对象存储
+	STORAGE_S3  = "s3"  // S3 兼容的对象存储，如 MinIO
+)
+
+// StorageConfig 私有存储桶，下载链接(镜像改写后)的 host 与 endpoint 相同时使用签名请求，其他链接匿名下载
+type StorageConfig struct {
+	Type         string `json:"type"`                       // cos、s3，为空时全部匿名下载
+	Endpoint     string `json:"endpoint"`                   // 存储桶地址，如 https://<bucket>-<appid>.cos.<region>.myqcloud.com、http://minio.local:9000
+	Region       string `json:"region"`                     // s3: 签名使用的区域，默认 us-east-1
+	AccessKey    string `json:"accessKey"`                  // cos: SecretId；s3: Access Key ID
+	SecretKey    string `json:"secretKey" secret:"true"`    // 密钥引用(file:/env:/enc:)
+	SessionToken string `json:"sessionToken" secret:"true"` // 临时凭证的 token，密钥引用(file:/env:/enc:)
+}
+
+// ReportConfig 状态上报，url 为空时只写本地日志
+type ReportConfig struct {
+	URL           string `json:"url"`                 // collector 地址
+	Token         string `json:"token" secret:"true"` // Authorization: Bearer，密钥引用(file:/env:/enc:)
+	BatchSize     int    `json:"batchSize"`           // 每批最多事件数
+	FlushInterval uint32 `json:"flushInterval"`       // 未满一批时的发送间隔，秒
+	QueueSize     int    `json:"queueSize"`           // 内存队列长度，满时丢弃最早的事件
+	MaxRetries    int    `json:"maxRetries"`          // 发送失败的重试次数
+}
+
+// nacos 连接协议
+const (
+	SCHEME_HTTP  = "http"
+	SCHEME_HTTPS = "https"
+)
+
+// NacosConfig nacos 连接与认证参数，服务端地址见 ipAddrs
+type NacosConfig struct {
+	Port        uint64 `json:"port"`                    // 服务端端口
+	ContextPath string `json:"contextPath"`             // 服务端 context path
+	Scheme      string `json:"scheme"`                  // http、https
+	CaFile      string `json:"caFile"`                  // https: 自定义 CA 证书(PEM)，为空时使用系统证书
+	Group       string `json:"group"`                   // 配置与服务的分组
+	TimeoutMs   uint64 `json:"timeoutMs"`               // 请求超时，毫秒
+	CacheDir    string `json:"cacheDir"`                // 本地缓存目录
+	LogDir      string `json:"logDir"`                  // nacos 客户端日志目录
+	Username    string `json:"username"`                // 用户名密码认证
+	Password    string `json:"password" secret:"true"`  // 密钥引用(file:/env:/enc:)
+	AccessKey   string `json:"accessKey"`               // AccessKey/SecretKey 认证
+	SecretKey   string `json:"secretKey" secret:"true"` // 密钥引用(file:/env:/enc:)
+}
 
-	// 配置对象
-	err = v.Unmarshal(&c)
+// VulnDbConfig 漏洞库信息，OSV/GHSA 格式的 json 或 zip 文件
+type VulnDbConfig struct {
+	DownLoadURL string `json:"downLoadURL"` // 下载链接
+	Md5         string `json:"md5"`         // 文件摘要，sha256:<hex>
+}
+
+// InitConfig 按照配置来源的优先级加载并校验配置，配置文件不存在时使用默认值
+func InitConfig(loader *Loader) (*Effective, error) {
+	effective, err := loader.Load()
 	if err != nil {
-		fmt.Printf("unmarshal config failed: %v\n", err)
+		return nil, err
+	}
+	for _, w := range effective.Warnings {
+		fmt.Printf("config warning: %s\n", w.Error())
 	}
-	return &c, nil
+	return effective, nil
+}
+
+// ParseConfig 解析配置中心下发的配置，未配置的项使用默认值
+func ParseConfig(data []byte) (*Config, error) {
+	c, _, err := CheckConfig(data)
+	return c, err
+}
+
+// CheckConfig 解析并校验配置，返回配置对象、未知配置项的告警以及校验错误
+func CheckConfig(data []byte) (*Config, []FieldError, error) {
+	var c Config
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("parse config: %v", err)
+	}
+	warnings := UnknownKeys(raw)
+
+	v := viper.New()
+	v.SetConfigType("json")
+	setDefaultValue(v)
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, warnings, fmt.Errorf("parse config: %v", err)
+	}
+	if err := v.Unmarshal(&c); err != nil {
+		return nil, warnings, fmt.Errorf("unmarshal config: %v", err)
+	}
+	warnings = append(warnings, c.inferConfigSource()...)
+	secretWarnings, secretErrs := checkSecrets(&c)
+	warnings = append(warnings, secretWarnings...)
+	errs := append(c.Validate(), secretErrs...)
+	if len(errs) > 0 {
+		return nil, warnings, errs
+	}
+	return &c, warnings, nil
 }
 
 // 给参数设置默认值
 func setDefaultValue(vp *viper.Viper) {
 	vp.SetDefault("AgentMode", STATIC)
 	vp.SetDefault("Namespace", "jrasp")
-	vp.SetDefault("EnableAttach", false)
 	vp.SetDefault("EnableAuth", true)
 	vp.SetDefault("LogLevel", 0)
 	vp.SetDefault("LogPath", "../logs/jrasp-daemon.log")
 	vp.SetDefault("EnablePprof", false)
 	vp.SetDefault("PprofPort", 6753)
-	vp.SetDefault("Password", "123456")
+	vp.SetDefault("Password", "file:cfg/agent.password") // 首次启动时随机生成
 	vp.SetDefault("Username", "admin")
-	vp.SetDefault("EnableDeleyExit", false)
-	vp.SetDefault("EnableResourceCheck", false)
 
 	vp.SetDefault("LogReportTicker", 6)
 	vp.SetDefault("ScanTicker", 30)
@@ -116,22 +247,49 @@ func setDefaultValue(vp *viper.Viper) {
 	vp.SetDefault("ProcessInjectTicker", 30)
 	vp.SetDefault("HeartBeatReportTicker", 5)
 	vp.SetDefault("DependencyTicker", 12*60*60)
-
-	vp.SetDefault("EnableBlock", false)
-	vp.SetDefault("EnableRceBlock", false)
-
-	vp.SetDefault("AttachTime", -1)
+	vp.SetDefault("UpdateTicker", 10)
 
 	vp.SetDefault("NamespaceId", "") // default 空间
-	// dev 环境：111.229.199.6
-	// prod 环境：139.224.220.2:8848,106.14.26.4:8848,47.101.64.183:8848
-	vp.SetDefault("IpAddrs", []string{"139.224.220.2","106.14.26.4","47.101.64.183"})
+	// 默认不连接任何 nacos 服务端，使用 nacos 时必须配置 ipAddrs
+	vp.SetDefault("IpAddrs", []string{})
 	vp.SetDefault("DataId", "")
+	vp.SetDefault("Nacos.Port", 8848)
+	vp.SetDefault("Nacos.ContextPath", "/nacos")
+	vp.SetDefault("Nacos.Scheme", SCHEME_HTTP)
+	vp.SetDefault("Nacos.Group", "DEFAULT_GROUP")
+	vp.SetDefault("Nacos.TimeoutMs", 5000)
+	vp.SetDefault("Nacos.CacheDir", "data/nacos/cache") // 相对路径基于安装目录
+	vp.SetDefault("Nacos.LogDir", "logs/nacos")
+	vp.SetDefault("Command.Type", SOURCE_NONE)
+	vp.SetDefault("Command.PollInterval", 30)
+	vp.SetDefault("Report.BatchSize", 100)
+	vp.SetDefault("Report.FlushInterval", 10)
+	vp.SetDefault("Report.QueueSize", 10000)
+	vp.SetDefault("Report.MaxRetries", 3)
+	vp.SetDefault("Download.MaxSize", 200)
+	vp.SetDefault("Download.MaxRetries", 3)
+	vp.SetDefault("Download.Timeout", 300)
+	// ConfigSource.Type 不设默认值，未配置时由 inferConfigSource 兼容旧配置
+	vp.SetDefault("ConfigSource.PollInterval", 60)
 
 	// 腾讯oss 配置
 	// 可执行文件配置,默认为空，不需要更新
-	vp.SetDefault("ExecOssFileName", "")
-	vp.SetDefault("ExecOssFileHash", "")
+	vp.SetDefault("ExeOssFileName", "")
+	vp.SetDefault("ExeOssFileHash", "")
+}
+
+// inferConfigSource 兼容未配置 configSource.type 的旧配置：旧版本总是使用 nacos，
+// 配置了 ipAddrs 或 dataId 时按 nacos 处理并告警，否则不使用配置来源
+func (config *Config) inferConfigSource() []FieldError {
+	if config.ConfigSource.Type != "" {
+		return nil
+	}
+	if len(config.IpAddrs) == 0 && config.DataId == "" {
+		config.ConfigSource.Type = SOURCE_NONE
+		return nil
+	}
+	config.ConfigSource.Type = SOURCE_NACOS
+	return []FieldError{{Field: "configSource.type", Message: "not set, using nacos because ipAddrs or dataId is set"}}
 }
 
 // IsDynamicMode IsDynamic 是否是动态注入模式
@@ -0,0 +1,25 @@
+package userconfig
+
+import "sync/atomic"
+
+// Current 当前生效的配置，热更新时发布新的快照，已发布的快照不再修改
+// 各协程每次使用时调用 Load 读取最新的快照，不保存快照
+type Current struct {
+	v atomic.Value
+}
+
+func NewCurrent(cfg *Config) *Current {
+	c := &Current{}
+	c.Store(cfg)
+	return c
+}
+
+// Load 当前的配置快照，只读
+func (c *Current) Load() *Config {
+	return c.v.Load().(*Config)
+}
+
+// Store 发布新的配置快照，调用后不能再修改 cfg
+func (c *Current) Store(cfg *Config) {
+	c.v.Store(cfg)
+}
@@ -0,0 +1,63 @@
+package userconfig
+
+import (
+	"reflect"
+	"strings"
+)
+
+// 修改后需要重启 daemon 才能生效的配置项
+var restartFields = map[string]bool{
+	"logPath":      true, // 日志文件在启动时打开
+	"enablePprof":  true, // pprof 端口在启动时监听
+	"pprofPort":    true,
+	"configSource": true, // 配置来源在启动时创建
+	"namespaceId":  true, // nacos 连接参数
+	"dataId":       true,
+	"ipAddrs":      true,
+	"nacos":        true,
+	"command":      true, // 命令通道在启动时创建
+	"report":       true, // 上报客户端在启动时创建
+}
+
+// Diff 比较两份配置，返回发生变化的配置项(json 名称)
+func Diff(old, new *Config) []string {
+	var changed []string
+	ov := reflect.ValueOf(old).Elem()
+	nv := reflect.ValueOf(new).Elem()
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			changed = append(changed, fieldName(t.Field(i)))
+		}
+	}
+	return changed
+}
+
+// NeedRestart 变化的配置项中需要重启才能生效的部分
+func NeedRestart(changed []string) []string {
+	var fields []string
+	for _, name := range changed {
+		if restartFields[name] {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// Contains 配置项是否发生了变化
+func Contains(changed []string, name string) bool {
+	for _, c := range changed {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldName(f reflect.StructField) string {
+	tag := strings.Split(f.Tag.Get("json"), ",")[0]
+	if tag == "" {
+		return f.Name
+	}
+	return tag
+}
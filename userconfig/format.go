@@ -0,0 +1,97 @@
+package userconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+)
+
+// 配置格式，按文件扩展名(nacos 为 dataId 的扩展名)识别
+const (
+	FORMAT_JSON = "json"
+	FORMAT_YAML = "yaml"
+	FORMAT_TOML = "toml"
+)
+
+// 安装目录下配置文件的查找顺序
+var configFileNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// FormatOf 按扩展名识别格式，没有扩展名时按内容识别: { 开头为 json，否则为 yaml
+func FormatOf(name string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return FORMAT_JSON
+	case ".yaml", ".yml":
+		return FORMAT_YAML
+	case ".toml":
+		return FORMAT_TOML
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] == '{' {
+		return FORMAT_JSON
+	}
+	return FORMAT_YAML
+}
+
+// ToJSON 把 yaml/toml 配置转换为 json，之后的合并、校验与 json 配置完全一致
+func ToJSON(format string, data []byte) ([]byte, error) {
+	var raw interface{}
+	switch format {
+	case FORMAT_JSON:
+		return data, nil
+	case FORMAT_YAML:
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("parse yaml: %v", err)
+		}
+	case FORMAT_TOML:
+		tree, err := toml.LoadBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse toml: %v", err)
+		}
+		raw = tree.ToMap()
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+	raw = stringKeys(raw)
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+	return json.Marshal(raw)
+}
+
+// ReadConfigFile 读取配置文件并转换为 json
+func ReadConfigFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ToJSON(FormatOf(path, data), data)
+}
+
+// yaml 解析出的 map 的 key 为 interface{}，json 只支持字符串 key
+func stringKeys(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return m
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = stringKeys(item)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = stringKeys(item)
+		}
+		return value
+	}
+	return v
+}
@@ -0,0 +1,446 @@
+package userconfig
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"jrasp-daemon/secret"
+	"jrasp-daemon/utils"
+
+	"github.com/spf13/viper"
+)
+
+// 配置来源，优先级从低到高
+const (
+	LAYER_DEFAULT = "default" // 内置默认值
+	LAYER_SYSTEM  = "system"  // 安装目录下的 cfg/config.json
+	LAYER_FILE    = "file"    // --config 指定的配置文件
+	LAYER_ENV     = "env"     // JRASP_* 环境变量
+	LAYER_FLAG    = "flag"    // 命令行参数
+	LAYER_REMOTE  = "remote"  // 配置中心下发
+)
+
+// 环境变量前缀，如 JRASP_AGENT_MODE、JRASP_LOG_LEVEL
+const ENV_PREFIX = "JRASP_"
+
+// Layer 一个配置来源，key 为配置项的 json 名称
+type Layer struct {
+	Name   string
+	Values map[string]interface{}
+}
+
+// Loader 按照 默认值 < 系统配置文件 < --config < 环境变量 < 命令行参数 < 配置中心 的顺序合并配置
+// 高优先级来源中的配置项整体覆盖低优先级来源中的同名配置项(moduleConfigMap 也是整体覆盖)
+type Loader struct {
+	InstallDir string            // 安装目录，用于解析密钥引用中的相对路径与主机密钥
+	SystemFile string            // 安装目录下的配置文件
+	ConfigFile string            // --config 指定的配置文件
+	Env        []string          // 环境变量，KEY=VALUE
+	Flags      map[string]string // 命令行参数，配置项 json 名称 -> 值
+	RemoteFile string            // 配置中心下发配置的本地缓存
+	Remote     []byte            // 配置中心下发的配置，为空时读取本地缓存
+}
+
+// Effective 合并后的配置以及每个配置项的来源
+type Effective struct {
+	Config   *Config
+	Values   map[string]interface{}
+	Sources  map[string]string
+	Warnings []FieldError
+}
+
+// SystemConfigFile 安装目录下的配置文件，按 config.json、config.yaml、config.yml、config.toml 的顺序查找
+func SystemConfigFile(installDir string) string {
+	if files := SystemConfigFiles(installDir); len(files) > 0 {
+		return files[0]
+	}
+	return filepath.Join(installDir, "cfg", configFileNames[0])
+}
+
+// SystemConfigFiles 安装目录下存在的全部配置文件，按查找顺序
+func SystemConfigFiles(installDir string) []string {
+	var files []string
+	for _, name := range configFileNames {
+		path := filepath.Join(installDir, "cfg", name)
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// CheckSystemConfigFiles 同时存在多个配置文件时只会使用第一个，修改其他文件不会生效，因此直接报错
+func CheckSystemConfigFiles(installDir string) error {
+	if files := SystemConfigFiles(installDir); len(files) > 1 {
+		return fmt.Errorf("multiple config files found: %s, keep only one", strings.Join(files, ", "))
+	}
+	return nil
+}
+
+// RemoteCacheFile 配置中心下发配置的本地缓存
+func RemoteCacheFile(installDir string) string {
+	return filepath.Join(installDir, "cfg", "remote.json")
+}
+
+// EffectiveFile 运行中的 daemon 最终生效的配置以及来源
+func EffectiveFile(installDir string) string {
+	return filepath.Join(installDir, "data", "effective-config.json")
+}
+
+// NewLoader 解析命令行参数，构造配置加载器
+// 支持: --config 文件路径、--agent-mode、--log-level、--log-path 以及可重复的 --set key=value
+func NewLoader(installDir string, args []string) (*Loader, error) {
+	fs := flag.NewFlagSet("jrasp-daemon", flag.ContinueOnError)
+	configFile := fs.String("config", "", "config file, overrides "+SystemConfigFile(installDir))
+	agentMode := fs.String("agent-mode", "", "agent mode: static, dynamic, disable")
+	logLevel := fs.String("log-level", "", "log level: -1(debug) ~ 5(fatal)")
+	logPath := fs.String("log-path", "", "log file path")
+	var sets setFlags
+	fs.Var(&sets, "set", "override a config key, key=value, can be repeated")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	flags := make(map[string]string)
+	for _, kv := range sets {
+		flags[kv[0]] = kv[1]
+	}
+	if *agentMode != "" {
+		flags["agentMode"] = *agentMode
+	}
+	if *logLevel != "" {
+		flags["logLevel"] = *logLevel
+	}
+	if *logPath != "" {
+		flags["logPath"] = *logPath
+	}
+	return &Loader{
+		InstallDir: installDir,
+		SystemFile: SystemConfigFile(installDir),
+		ConfigFile: *configFile,
+		Env:        os.Environ(),
+		Flags:      flags,
+		RemoteFile: RemoteCacheFile(installDir),
+	}, nil
+}
+
+// WithRemote 使用新下发的配置，其他来源不变
+func (l *Loader) WithRemote(data []byte) *Loader {
+	n := *l
+	n.Remote = data
+	return &n
+}
+
+// WithoutRemote 不使用配置中心的配置，用于判断配置错误是否来自配置中心
+func (l *Loader) WithoutRemote() *Loader {
+	n := *l
+	n.Remote = nil
+	n.RemoteFile = ""
+	return &n
+}
+
+// Valid 合并后的配置是否合法
+func (l *Loader) Valid() bool {
+	_, err := l.Load()
+	return err == nil
+}
+
+type setFlags [][2]string
+
+func (s *setFlags) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *setFlags) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("must be key=value, got %q", value)
+	}
+	*s = append(*s, [2]string{kv[0], kv[1]})
+	return nil
+}
+
+// Load 合并全部来源并校验
+func (l *Loader) Load() (*Effective, error) {
+	if l.InstallDir != "" {
+		if err := CheckSystemConfigFiles(l.InstallDir); err != nil {
+			return nil, err
+		}
+	}
+	layers, warnings, err := l.layers()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	sources := make(map[string]string)
+	for _, layer := range layers {
+		for key, value := range layer.Values {
+			values[key] = value
+			sources[key] = layer.Name
+		}
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	c, w, err := CheckConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, w...)
+	if err := resolveSecrets(c, secret.NewResolver(l.InstallDir)); err != nil {
+		return nil, err
+	}
+	return &Effective{Config: c, Values: values, Sources: sources, Warnings: warnings}, nil
+}
+
+func (l *Loader) layers() ([]Layer, []FieldError, error) {
+	var layers []Layer
+	var warnings []FieldError
+
+	layers = append(layers, Layer{Name: LAYER_DEFAULT, Values: defaultValues()})
+
+	files := []struct {
+		name     string
+		path     string
+		required bool
+	}{
+		{LAYER_SYSTEM, l.SystemFile, false},
+		{LAYER_FILE, l.ConfigFile, true},
+	}
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		data, err := ReadConfigFile(f.path)
+		if err != nil {
+			if os.IsNotExist(err) && !f.required {
+				continue
+			}
+			return nil, nil, fmt.Errorf("read %s config %s: %v", f.name, f.path, err)
+		}
+		layer, w, err := parseLayer(f.name, data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s config %s: %v", f.name, f.path, err)
+		}
+		layers = append(layers, layer)
+		warnings = append(warnings, w...)
+	}
+
+	env, w, err := envLayer(l.Env)
+	if err != nil {
+		return nil, nil, err
+	}
+	layers = append(layers, env)
+	warnings = append(warnings, w...)
+
+	flags, err := stringLayer(LAYER_FLAG, l.Flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	layers = append(layers, flags)
+
+	remote := l.Remote
+	if len(remote) == 0 && l.RemoteFile != "" {
+		remote, _ = ioutil.ReadFile(l.RemoteFile)
+	}
+	if len(remote) > 0 {
+		layer, w, err := parseLayer(LAYER_REMOTE, remote)
+		if err != nil {
+			return nil, nil, fmt.Errorf("remote config: %v", err)
+		}
+		layers = append(layers, layer)
+		warnings = append(warnings, w...)
+	}
+	return layers, warnings, nil
+}
+
+// parseLayer 解析配置文件内容，配置项名称统一为 json 名称
+func parseLayer(name string, data []byte) (Layer, []FieldError, error) {
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Layer{}, nil, err
+	}
+	var warnings []FieldError
+	for _, w := range UnknownKeys(raw) {
+		w.Field = name + ":" + w.Field
+		warnings = append(warnings, w)
+	}
+	layer := Layer{Name: name, Values: make(map[string]interface{})}
+	for key, value := range raw {
+		if jsonName, ok := configKeys()[strings.ToLower(key)]; ok {
+			layer.Values[jsonName] = value
+		}
+	}
+	return layer, warnings, nil
+}
+
+// envLayer JRASP_AGENT_MODE=dynamic 对应配置项 agentMode
+func envLayer(environ []string) (Layer, []FieldError, error) {
+	values := make(map[string]string)
+	var warnings []FieldError
+	envKeys := make(map[string]string)
+	for _, jsonName := range configKeys() {
+		envKeys[EnvName(jsonName)] = jsonName
+	}
+	for _, kv := range environ {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 || !strings.HasPrefix(pair[0], ENV_PREFIX) {
+			continue
+		}
+		jsonName, ok := envKeys[pair[0]]
+		if !ok {
+			warnings = append(warnings, FieldError{Field: LAYER_ENV + ":" + pair[0], Message: "unknown key, ignored"})
+			continue
+		}
+		values[jsonName] = pair[1]
+	}
+	layer, err := stringLayer(LAYER_ENV, values)
+	return layer, warnings, err
+}
+
+// stringLayer 环境变量与命令行参数的值都是字符串，map/结构体(以及结构体数组)类型的配置项使用 json 格式
+func stringLayer(name string, values map[string]string) (Layer, error) {
+	layer := Layer{Name: name, Values: make(map[string]interface{})}
+	types := configTypes()
+	for key, value := range values {
+		jsonName, ok := configKeys()[strings.ToLower(key)]
+		if !ok {
+			return Layer{}, fmt.Errorf("%s: unknown config key %q", name, key)
+		}
+		switch types[jsonName].Kind() {
+		case reflect.Map, reflect.Struct:
+			var v interface{}
+			if err := json.Unmarshal([]byte(value), &v); err != nil {
+				return Layer{}, fmt.Errorf("%s: %s must be json: %v", name, key, err)
+			}
+			layer.Values[jsonName] = v
+		case reflect.Slice:
+			if types[jsonName].Elem().Kind() == reflect.Struct {
+				var v []interface{}
+				if err := json.Unmarshal([]byte(value), &v); err != nil {
+					return Layer{}, fmt.Errorf("%s: %s must be json array: %v", name, key, err)
+				}
+				layer.Values[jsonName] = v
+				continue
+			}
+			layer.Values[jsonName] = strings.Split(value, ",")
+		case reflect.Int, reflect.Int64, reflect.Int32:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return Layer{}, fmt.Errorf("%s: %s must be integer: %q", name, key, value)
+			}
+			layer.Values[jsonName] = n
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return Layer{}, fmt.Errorf("%s: %s must be bool: %q", name, key, value)
+			}
+			layer.Values[jsonName] = b
+		default:
+			layer.Values[jsonName] = value
+		}
+	}
+	return layer, nil
+}
+
+// defaultValues 内置默认值，与 setDefaultValue 保持一致
+func defaultValues() map[string]interface{} {
+	v := viper.New()
+	setDefaultValue(v)
+	values := make(map[string]interface{})
+	for key, value := range v.AllSettings() {
+		if jsonName, ok := configKeys()[key]; ok {
+			values[jsonName] = value
+		}
+	}
+	return values
+}
+
+// configKeys 小写名称 -> json 名称
+func configKeys() map[string]string {
+	keys := make(map[string]string)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		name := fieldName(t.Field(i))
+		keys[strings.ToLower(name)] = name
+	}
+	return keys
+}
+
+func configTypes() map[string]reflect.Type {
+	types := make(map[string]reflect.Type)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		types[fieldName(t.Field(i))] = t.Field(i).Type
+	}
+	return types
+}
+
+// ConfigKeys 全部配置项的 json 名称，按字母排序
+func ConfigKeys() []string {
+	var keys []string
+	for _, name := range configKeys() {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EnvName 配置项对应的环境变量，如 heartBeatReportTicker -> JRASP_HEART_BEAT_REPORT_TICKER
+func EnvName(jsonName string) string {
+	var b strings.Builder
+	b.WriteString(ENV_PREFIX)
+	runes := []rune(jsonName)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// EffectiveValue 配置项的值与来源
+type EffectiveValue struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// Dump 全部配置项的值与来源，明文密钥替换为 ******
+func (e *Effective) Dump() map[string]EffectiveValue {
+	dump := make(map[string]EffectiveValue)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := fieldName(f)
+		source, ok := e.Sources[key]
+		if !ok {
+			source = LAYER_DEFAULT
+		}
+		value := redactValue(e.Values[key], f.Type, f.Tag.Get("secret") == "true")
+		dump[key] = EffectiveValue{Value: value, Source: source}
+	}
+	return dump
+}
+
+// WriteEffective 保存最终生效的配置以及来源，供 config show 命令查看
+func WriteEffective(installDir string, e *Effective) error {
+	path := EffectiveFile(installDir)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := utils.MarshalIndent(e.Dump())
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data, 0600)
+}
@@ -0,0 +1,179 @@
+package userconfig
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Selector 应用选择器，配置了的条件全部满足时命中
+type Selector struct {
+	MainClass string            `json:"mainClass"` // 启动类，支持 * 通配，如 org.apache.catalina.startup.*
+	Jar       string            `json:"jar"`       // -jar 启动的jar包文件名，支持 * 通配，如 order-*.jar
+	CmdLine   string            `json:"cmdLine"`   // 完整命令行的正则
+	Labels    map[string]string `json:"labels"`    // 容器标签，值为空时只要求标签存在
+}
+
+// Overlay 按应用覆盖的配置，按配置顺序匹配，第一个命中的生效
+type Overlay struct {
+	Name       string                       `json:"name"`
+	Selector   Selector                     `json:"selector"`
+	AgentMode  AgentMode                    `json:"agentMode"`  // 为空时使用全局配置
+	Modules    []string                     `json:"modules"`    // 启用的模块，为空时启用全部模块
+	Parameters map[string]map[string]string `json:"parameters"` // 模块名 -> 覆盖的参数
+}
+
+// AppIdentity 用于匹配选择器的应用信息
+type AppIdentity struct {
+	MainClass string
+	Jar       string
+	CmdLine   string
+	Labels    map[string]string
+}
+
+// AppConfig 单个应用最终生效的配置
+type AppConfig struct {
+	Overlay         string // 命中的覆盖配置名称，为空表示使用全局配置
+	AgentMode       AgentMode
+	ModuleConfigMap map[string]ModuleConfig
+}
+
+// Match 选择器是否命中，没有任何条件的选择器不命中
+func (s *Selector) Match(app AppIdentity) bool {
+	if s.empty() {
+		return false
+	}
+	if s.MainClass != "" && !glob(s.MainClass, app.MainClass) {
+		return false
+	}
+	if s.Jar != "" && !glob(s.Jar, path.Base(app.Jar)) {
+		return false
+	}
+	if s.CmdLine != "" {
+		re, err := regexp.Compile(s.CmdLine)
+		if err != nil || !re.MatchString(app.CmdLine) {
+			return false
+		}
+	}
+	for k, v := range s.Labels {
+		actual, ok := label(app.Labels, k)
+		if !ok || (v != "" && v != actual) {
+			return false
+		}
+	}
+	return true
+}
+
+// 配置经过 viper 解析后 key 为小写，标签名按不区分大小写匹配
+func label(labels map[string]string, key string) (string, bool) {
+	if v, ok := labels[key]; ok {
+		return v, true
+	}
+	for k, v := range labels {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func (s *Selector) empty() bool {
+	return s.MainClass == "" && s.Jar == "" && s.CmdLine == "" && len(s.Labels) == 0
+}
+
+func glob(pattern, name string) bool {
+	if name == "" {
+		return false
+	}
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
+
+// AppConfig 按覆盖配置计算应用的运行模式与模块配置，不修改全局配置
+func (config *Config) AppConfig(app AppIdentity) AppConfig {
+	result := AppConfig{AgentMode: config.AgentMode, ModuleConfigMap: config.ModuleConfigMap}
+	for i := range config.Overlays {
+		o := &config.Overlays[i]
+		if !o.Selector.Match(app) {
+			continue
+		}
+		result.Overlay = o.Name
+		if o.AgentMode != "" {
+			result.AgentMode = o.AgentMode
+		}
+		if len(o.Modules) == 0 && len(o.Parameters) == 0 {
+			return result
+		}
+		modules := make(map[string]ModuleConfig)
+		for name, m := range config.ModuleConfigMap {
+			if len(o.Modules) > 0 && !Contains(o.Modules, name) {
+				continue
+			}
+			if params, ok := o.Parameters[name]; ok {
+				merged := make(map[string]string, len(m.Parameters)+len(params))
+				for k, v := range m.Parameters {
+					merged[k] = v
+				}
+				for k, v := range params {
+					merged[k] = v
+				}
+				m.Parameters = merged
+			}
+			modules[name] = m
+		}
+		result.ModuleConfigMap = modules
+		return result
+	}
+	return result
+}
+
+// validateOverlays 覆盖配置只能选择、修改已配置的模块，不能新增模块
+func (config *Config) validateOverlays(add func(field, format string, v ...interface{})) {
+	names := make(map[string]bool)
+	for i, o := range config.Overlays {
+		prefix := fmt.Sprintf("overlays[%d].", i)
+		if o.Name == "" {
+			add(prefix+"name", "is required")
+		} else if names[o.Name] {
+			add(prefix+"name", "duplicate overlay %q", o.Name)
+		}
+		names[o.Name] = true
+		if o.Selector.empty() {
+			add(prefix+"selector", "at least one of mainClass, jar, cmdLine, labels is required")
+		}
+		for _, pattern := range []struct{ field, value string }{{"mainClass", o.Selector.MainClass}, {"jar", o.Selector.Jar}} {
+			if _, err := path.Match(pattern.value, ""); err != nil {
+				add(prefix+"selector."+pattern.field, "bad pattern %q", pattern.value)
+			}
+		}
+		if o.Selector.CmdLine != "" {
+			if _, err := regexp.Compile(o.Selector.CmdLine); err != nil {
+				add(prefix+"selector.cmdLine", "bad regexp: %v", err)
+			}
+		}
+		switch o.AgentMode {
+		case "", STATIC, DYNAMIC, DISABLE:
+		default:
+			add(prefix+"agentMode", "must be one of static, dynamic, disable, got %q", o.AgentMode)
+		}
+		for _, m := range o.Modules {
+			if _, ok := config.ModuleConfigMap[m]; !ok {
+				add(prefix+"modules", "unknown module %q", m)
+			}
+		}
+		modules := make([]string, 0, len(o.Parameters))
+		for m := range o.Parameters {
+			modules = append(modules, m)
+		}
+		sort.Strings(modules)
+		for _, m := range modules {
+			if _, ok := config.ModuleConfigMap[m]; !ok {
+				add(prefix+"parameters."+m, "unknown module %q", m)
+			} else if len(o.Modules) > 0 && !Contains(o.Modules, m) {
+				add(prefix+"parameters."+m, "module %q is not enabled by this overlay", m)
+			}
+		}
+	}
+}
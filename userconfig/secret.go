@@ -0,0 +1,110 @@
+package userconfig
+
+import (
+	"fmt"
+	"jrasp-daemon/secret"
+	"jrasp-daemon/zlog"
+	"reflect"
+	"strings"
+)
+
+// 带有 secret:"true" 标签的配置项保存密钥引用(file:/env:/enc:)，加载时解析为明文
+// 配置输出、日志中只出现引用或 ******
+
+// walkSecrets 遍历配置中的密钥字段，包括嵌套结构体中的字段
+func walkSecrets(v reflect.Value, prefix string, fn func(field string, value reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := prefix + fieldName(f)
+		switch {
+		case f.Type.Kind() == reflect.String && f.Tag.Get("secret") == "true":
+			if err := fn(name, v.Field(i)); err != nil {
+				return err
+			}
+		case f.Type.Kind() == reflect.Struct:
+			if err := walkSecrets(v.Field(i), name+".", fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSecrets 把密钥引用替换为明文
+func resolveSecrets(c *Config, r *secret.Resolver) error {
+	return walkSecrets(reflect.ValueOf(c).Elem(), "", func(field string, value reflect.Value) error {
+		if value.String() == "" {
+			return nil
+		}
+		plain, err := r.Resolve(value.String())
+		if err != nil {
+			return fmt.Errorf("%s: %v", field, err)
+		}
+		value.SetString(plain)
+		return nil
+	})
+}
+
+// checkSecrets 校验引用格式，明文密钥输出告警
+func checkSecrets(c *Config) (warnings []FieldError, errs []FieldError) {
+	_ = walkSecrets(reflect.ValueOf(c).Elem(), "", func(field string, value reflect.Value) error {
+		s := value.String()
+		if s == "" {
+			return nil
+		}
+		if !secret.IsReference(s) {
+			// 引用的明文在解析时检查长度
+			if len(s) < zlog.MinSecretLen {
+				errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("secret must be at least %d characters", zlog.MinSecretLen)})
+			}
+			warnings = append(warnings, FieldError{Field: field, Message: "secret is stored in plaintext, use file:, env: or enc: instead"})
+		} else if err := secret.CheckReference(s); err != nil {
+			errs = append(errs, FieldError{Field: field, Message: err.Error()})
+		}
+		return nil
+	})
+	return warnings, errs
+}
+
+// SecretFields 包含密钥的顶层配置项
+func SecretFields() []string {
+	var fields []string
+	_ = walkSecrets(reflect.New(reflect.TypeOf(Config{})).Elem(), "", func(field string, value reflect.Value) error {
+		top := strings.SplitN(field, ".", 2)[0]
+		if !Contains(fields, top) {
+			fields = append(fields, top)
+		}
+		return nil
+	})
+	return fields
+}
+
+// redactValue 隐藏配置输出中的明文密钥，value 为 json 解析后的原始值
+func redactValue(value interface{}, t reflect.Type, secretTag bool) interface{} {
+	switch {
+	case t.Kind() == reflect.String && secretTag:
+		if s, ok := value.(string); ok {
+			return secret.Redact(s)
+		}
+	case t.Kind() == reflect.Struct:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		redacted := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			redacted[k] = v
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			for k, v := range m {
+				if strings.EqualFold(k, fieldName(f)) {
+					redacted[k] = redactValue(v, f.Type, f.Tag.Get("secret") == "true")
+				}
+			}
+		}
+		return redacted
+	}
+	return value
+}
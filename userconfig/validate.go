@@ -0,0 +1,352 @@
+package userconfig
+
+import (
+	"fmt"
+	"jrasp-daemon/artifact"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ModuleType 模块类型
+const (
+	HOOK_MODULE      = "hook"
+	ALGORITHM_MODULE = "algorithm"
+)
+
+// FieldError 单个配置项的错误，Field 为配置项路径，如 moduleConfigMap.rce-hook.md5
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError 配置校验失败的全部错误
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+var (
+	activeTime = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d$`)
+	hostName   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
+)
+
+// Validate 校验配置项的取值，返回全部错误
+func (config *Config) Validate() ValidationError {
+	var errs ValidationError
+	add := func(field, format string, v ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, v...)})
+	}
+
+	switch config.AgentMode {
+	case STATIC, DYNAMIC, DISABLE:
+	default:
+		add("agentMode", "must be one of static, dynamic, disable, got %q", config.AgentMode)
+	}
+	if config.ActiveTime != "" && !activeTime.MatchString(config.ActiveTime) {
+		add("activeTime", "must be HH:MM, got %q", config.ActiveTime)
+	}
+	if config.Namespace == "" {
+		add("namespace", "is required")
+	}
+	if config.EnableAuth {
+		if config.Username == "" {
+			add("username", "is required when enableAuth is true")
+		}
+		if config.Password == "" {
+			add("password", "is required when enableAuth is true")
+		}
+	}
+	if config.LogLevel < -1 || config.LogLevel > 5 {
+		add("logLevel", "must be between -1(debug) and 5(fatal), got %d", config.LogLevel)
+	}
+	if config.LogPath == "" {
+		add("logPath", "is required")
+	}
+	if config.EnablePprof && (config.PprofPort <= 0 || config.PprofPort > 65535) {
+		add("pprofPort", "must be between 1 and 65535, got %d", config.PprofPort)
+	}
+
+	tickers := []struct {
+		name  string
+		value uint64
+	}{
+		{"logReportTicker", uint64(config.LogReportTicker)},
+		{"scanTicker", uint64(config.ScanTicker)},
+		{"pidExistsTicker", uint64(config.PidExistsTicker)},
+		{"processInjectTicker", uint64(config.ProcessInjectTicker)},
+		{"heartBeatReportTicker", uint64(config.HeartBeatReportTicker)},
+		{"dependencyTicker", uint64(config.DependencyTicker)},
+		{"updateTicker", uint64(config.UpdateTicker)},
+	}
+	for _, t := range tickers {
+		if t.value == 0 {
+			add(t.name, "must be positive")
+		}
+	}
+
+	for i, addr := range config.IpAddrs {
+		if net.ParseIP(addr) == nil && !hostName.MatchString(addr) {
+			add(fmt.Sprintf("ipAddrs[%d]", i), "must be an ip address or host name, got %q", addr)
+		}
+	}
+
+	if config.ExeOssFileName != "" || config.ExeOssFileHash != "" {
+		checkArtifactURL(add, "exeOssFileName", config.ExeOssFileName)
+		checkHash(add, "exeOssFileHash", config.ExeOssFileHash)
+	}
+
+	names := make([]string, 0, len(config.ModuleConfigMap))
+	for name := range config.ModuleConfigMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := config.ModuleConfigMap[name]
+		prefix := "moduleConfigMap." + name + "."
+		if m.ModuleName == "" {
+			add(prefix+"moduleName", "is required")
+		} else if !strings.EqualFold(m.ModuleName, name) {
+			add(prefix+"moduleName", "must equal the map key %q, got %q", name, m.ModuleName)
+		}
+		switch m.ModuleType {
+		case HOOK_MODULE, ALGORITHM_MODULE:
+		default:
+			add(prefix+"moduleType", "must be one of hook, algorithm, got %q", m.ModuleType)
+		}
+		if len(m.Parameters) > 0 && m.RouterPath == "" {
+			add(prefix+"routerPath", "is required when parameters are set")
+		}
+		checkArtifactURL(add, prefix+"downLoadURL", m.DownLoadURL)
+		checkHash(add, prefix+"md5", m.Md5)
+	}
+
+	config.validateNacos(add)
+
+	switch config.ConfigSource.Type {
+	case SOURCE_NACOS:
+		if len(config.IpAddrs) == 0 {
+			add("ipAddrs", "is required when configSource.type is nacos")
+		}
+	case SOURCE_NONE:
+	case SOURCE_FILE:
+		if config.ConfigSource.Path == "" {
+			add("configSource.path", "is required when type is file")
+		}
+	case SOURCE_HTTP:
+		checkURL(add, "configSource.url", config.ConfigSource.URL)
+		if config.ConfigSource.PollInterval == 0 {
+			add("configSource.pollInterval", "must be positive")
+		}
+	default:
+		add("configSource.type", "must be one of nacos, file, http, none, got %q", config.ConfigSource.Type)
+	}
+
+	switch config.Command.Type {
+	case SOURCE_NACOS:
+		if config.ConfigSource.Type != SOURCE_NACOS {
+			add("command.type", "nacos requires configSource.type nacos")
+		}
+	case SOURCE_HTTP:
+		checkURL(add, "command.url", config.Command.URL)
+		checkURL(add, "command.resultUrl", config.Command.ResultURL)
+		if config.Command.PollInterval == 0 {
+			add("command.pollInterval", "must be positive")
+		}
+	case SOURCE_NONE:
+	default:
+		add("command.type", "must be one of nacos, http, none, got %q", config.Command.Type)
+	}
+	for i, uploadURL := range config.Command.UploadURLs {
+		checkURL(add, fmt.Sprintf("command.uploadUrls[%d]", i), uploadURL)
+	}
+
+	if config.Report.URL != "" {
+		checkURL(add, "report.url", config.Report.URL)
+	}
+	if config.Report.BatchSize <= 0 {
+		add("report.batchSize", "must be positive")
+	}
+	if config.Report.FlushInterval == 0 {
+		add("report.flushInterval", "must be positive")
+	}
+	if config.Report.QueueSize < config.Report.BatchSize {
+		add("report.queueSize", "must not be less than batchSize %d", config.Report.BatchSize)
+	}
+	if config.Report.MaxRetries < 0 {
+		add("report.maxRetries", "must not be negative")
+	}
+
+	if config.Download.Proxy != "" {
+		if u, err := url.Parse(config.Download.Proxy); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			add("download.proxy", "must be an http, https or socks5 url")
+		} else if u.User != nil {
+			add("download.proxy", "must not contain credentials, use proxyUsername and proxyPassword")
+		}
+	}
+	if (config.Download.ProxyUsername == "") != (config.Download.ProxyPassword == "") {
+		add("download.proxyPassword", "proxyUsername and proxyPassword must be set together")
+	}
+	if config.Download.Mirror != "" {
+		checkArtifactURL(add, "download.mirror", config.Download.Mirror)
+	}
+	switch st := config.Download.Storage; st.Type {
+	case "":
+	case STORAGE_COS, STORAGE_S3:
+		if u, err := url.Parse(st.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("download.storage.endpoint", "must be an http(s) url, got %q", st.Endpoint)
+		}
+		if st.AccessKey == "" || st.SecretKey == "" {
+			add("download.storage.accessKey", "accessKey and secretKey are required")
+		}
+	default:
+		add("download.storage.type", "must be one of cos, s3, got %q", st.Type)
+	}
+	if config.Download.MaxSize <= 0 {
+		add("download.maxSize", "must be greater than 0")
+	}
+	if config.Download.MaxRetries < 0 {
+		add("download.maxRetries", "must not be negative")
+	}
+	if config.Download.Timeout == 0 {
+		add("download.timeout", "must be greater than 0")
+	}
+
+	config.validateOverlays(add)
+
+	if config.VulnDbConfig.DownLoadURL != "" || config.VulnDbConfig.Md5 != "" {
+		checkArtifactURL(add, "vulnDbConfig.downLoadURL", config.VulnDbConfig.DownLoadURL)
+		checkHash(add, "vulnDbConfig.md5", config.VulnDbConfig.Md5)
+	}
+	return errs
+}
+
+func checkURL(add func(field, format string, v ...interface{}), field, value string) {
+	if value == "" {
+		add(field, "is required")
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add(field, "must be an http(s) url, got %q", value)
+	}
+}
+
+// checkArtifactURL 制品的下载链接，可以是 http(s) 或本地文件 file:///path
+func checkArtifactURL(add func(field, format string, v ...interface{}), field, value string) {
+	if value == "" {
+		add(field, "is required")
+		return
+	}
+	u, err := url.Parse(value)
+	if err == nil && u.Scheme == "file" {
+		if u.Host != "" && u.Host != "localhost" || !strings.HasPrefix(u.Path, "/") {
+			add(field, "must be an absolute file url like file:///path, got %q", value)
+		}
+		return
+	}
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add(field, "must be an http(s) or file url, got %q", value)
+	}
+}
+
+func checkHash(add func(field, format string, v ...interface{}), field, value string) {
+	if value == "" {
+		add(field, "is required")
+		return
+	}
+	if _, err := artifact.ParseDigest(value); err != nil {
+		add(field, "%v", err)
+	}
+}
+
+// UnknownKeys 配置中存在但是结构体中没有的配置项，一般为拼写错误
+func UnknownKeys(raw map[string]interface{}) []FieldError {
+	var warnings []FieldError
+	unknownKeys(raw, reflect.TypeOf(Config{}), "", &warnings)
+	sort.Slice(warnings, func(i, j int) bool {
+		return warnings[i].Field < warnings[j].Field
+	})
+	return warnings
+}
+
+func unknownKeys(raw map[string]interface{}, t reflect.Type, prefix string, warnings *[]FieldError) {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fields[strings.ToLower(fieldName(f))] = f.Type
+	}
+	for key, value := range raw {
+		ft, ok := fields[strings.ToLower(key)]
+		if !ok {
+			*warnings = append(*warnings, FieldError{Field: prefix + key, Message: "unknown key, ignored"})
+			continue
+		}
+		// 模块配置：map[string]ModuleConfig
+		if ft.Kind() == reflect.Map && ft.Elem().Kind() == reflect.Struct {
+			if entries, ok := value.(map[string]interface{}); ok {
+				for name, entry := range entries {
+					if m, ok := entry.(map[string]interface{}); ok {
+						unknownKeys(m, ft.Elem(), prefix+key+"."+name+".", warnings)
+					}
+				}
+			}
+			continue
+		}
+		if ft.Kind() == reflect.Struct {
+			if m, ok := value.(map[string]interface{}); ok {
+				unknownKeys(m, ft, prefix+key+".", warnings)
+			}
+		}
+		// 覆盖配置：[]Overlay
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct {
+			if list, ok := value.([]interface{}); ok {
+				for i, entry := range list {
+					if m, ok := entry.(map[string]interface{}); ok {
+						unknownKeys(m, ft.Elem(), fmt.Sprintf("%s%s[%d].", prefix, key, i), warnings)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (config *Config) validateNacos(add func(field, format string, v ...interface{})) {
+	n := config.Nacos
+	if n.Port == 0 || n.Port > 65535 {
+		add("nacos.port", "must be between 1 and 65535, got %d", n.Port)
+	}
+	switch n.Scheme {
+	case SCHEME_HTTP:
+		if n.CaFile != "" {
+			add("nacos.caFile", "requires scheme https")
+		}
+	case SCHEME_HTTPS:
+	default:
+		add("nacos.scheme", "must be one of http, https, got %q", n.Scheme)
+	}
+	if n.Group == "" {
+		add("nacos.group", "is required")
+	}
+	if n.TimeoutMs == 0 {
+		add("nacos.timeoutMs", "must be positive")
+	}
+	if (n.Username == "") != (n.Password == "") {
+		add("nacos.username", "username and password must be set together")
+	}
+	if (n.AccessKey == "") != (n.SecretKey == "") {
+		add("nacos.accessKey", "accessKey and secretKey must be set together")
+	}
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+type fileDigest struct {
+	size    int64
+	modTime int64
+	sha1    string
+	sha256  string
+}
+
+// DigestCache jar包hash缓存，按照 路径+大小+修改时间 判断文件是否变化
+type DigestCache struct {
+	mu      sync.Mutex
+	digests map[string]fileDigest
+}
+
+func NewDigestCache() *DigestCache {
+	return &DigestCache{digests: make(map[string]fileDigest)}
+}
+
+// Get 返回文件的 sha1 与 sha256，文件不存在或者为嵌套jar包(a.jar!/BOOT-INF/lib/b.jar)时返回空
+// root 为进程所在的根文件系统(容器内的进程为 /proc/<pid>/root)，path 为进程视角的路径，缓存按解析后的路径保存
+func (c *DigestCache) Get(root, path string) (string, string) {
+	if path == "" || strings.Contains(path, "!") {
+		return "", ""
+	}
+	path = root + strings.TrimPrefix(path, "file:")
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return "", ""
+	}
+	c.mu.Lock()
+	cached, ok := c.digests[path]
+	c.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime == info.ModTime().UnixNano() {
+		return cached.sha1, cached.sha256
+	}
+	sha1Hex, sha256Hex, err := GetFileDigests(path)
+	if err != nil {
+		return "", ""
+	}
+	c.mu.Lock()
+	c.digests[path] = fileDigest{size: info.Size(), modTime: info.ModTime().UnixNano(), sha1: sha1Hex, sha256: sha256Hex}
+	c.mu.Unlock()
+	return sha1Hex, sha256Hex
+}
@@ -1,10 +1,14 @@
 package utils
 
 import (
-	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
 )
 
 func PathExists(path string) (bool, error) {
@@ -18,22 +22,57 @@ func PathExists(path string) (bool, error) {
 	return false, err
 }
 
-func GetFileHash(pluginPath string) (string, error) {
-	file, err := os.Open(pluginPath)
+// WriteFileAtomic 先写临时文件再rename，避免进程中断时留下写了一半的文件
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+".tmp")
 	if err != nil {
-		return "", err
+		return err
 	}
+	tmpName := tmp.Name()
 	defer func() {
-		ferr := file.Close()
-		if ferr != nil {
-			err = ferr
-		}
+		_ = os.Remove(tmpName) // rename 成功后删除不会生效
 	}()
-	// md5 方便在mac上机算
-	h := md5.New()
-	_, err = io.Copy(h, file)
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// SafeFileName 将任意标识(如包含路径的应用标识)转换为文件名，使用可读前缀加hash避免冲突
+func SafeFileName(id string) string {
+	sum := sha1.Sum([]byte(id))
+	prefix := unsafeFileChars.ReplaceAllString(id, "_")
+	if len(prefix) > 64 {
+		prefix = prefix[:64]
+	}
+	return prefix + "-" + hex.EncodeToString(sum[:4])
+}
+
+// GetFileDigests 计算文件的 sha1 与 sha256，jar包的 sha1 用于匹配 maven 仓库
+func GetFileDigests(path string) (sha1Hex string, sha256Hex string, err error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+	defer file.Close()
+	h1 := sha1.New()
+	h256 := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(h1, h256), file); err != nil {
+		return "", "", err
 	}
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return hex.EncodeToString(h1.Sum(nil)), hex.EncodeToString(h256.Sum(nil)), nil
 }
@@ -13,3 +13,8 @@ func ToString(t interface{}) string {
 	}
 	return string(bytes)
 }
+
+// MarshalIndent 格式化的json，用于落盘文件
+func MarshalIndent(t interface{}) ([]byte, error) {
+	return json.MarshalIndent(t, "", "  ")
+}
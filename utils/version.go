@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maven 版本限定词的顺序: alpha < beta < milestone < rc < snapshot < release < sp < 其他
+var qualifierOrder = map[string]int{
+	"alpha":     0,
+	"a":         0,
+	"beta":      1,
+	"b":         1,
+	"milestone": 2,
+	"m":         2,
+	"rc":        3,
+	"cr":        3,
+	"snapshot":  4,
+	"":          5,
+	"ga":        5,
+	"final":     5,
+	"release":   5,
+	"sp":        6,
+}
+
+// CompareVersion 按照maven的版本语义比较版本号
+// a < b 返回 -1，a == b 返回 0，a > b 返回 1
+func CompareVersion(a, b string) int {
+	as := splitVersion(a)
+	bs := splitVersion(b)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		if c := compareItem(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// 版本号按照 "."、"-" 以及数字与字母的交界处拆分
+func splitVersion(v string) []string {
+	v = strings.ToLower(strings.TrimSpace(v))
+	var items []string
+	var cur strings.Builder
+	var lastDigit bool
+	flush := func() {
+		if cur.Len() > 0 {
+			items = append(items, cur.String())
+			cur.Reset()
+		}
+	}
+	for i, r := range v {
+		if r == '.' || r == '-' || r == '_' || r == '+' {
+			flush()
+			continue
+		}
+		isDigit := unicode.IsDigit(r)
+		if i > 0 && cur.Len() > 0 && isDigit != lastDigit {
+			flush()
+		}
+		cur.WriteRune(r)
+		lastDigit = isDigit
+	}
+	flush()
+	// 去掉末尾的 0 与 release 类限定词，使得 1.0 == 1.0.0 == 1.0-final
+	for len(items) > 0 {
+		last := items[len(items)-1]
+		if n, err := strconv.Atoi(last); (err == nil && n == 0) || isReleaseQualifier(last) {
+			items = items[:len(items)-1]
+			continue
+		}
+		break
+	}
+	return items
+}
+
+func compareItem(x, y string) int {
+	// 缺失项与限定词比较时视为 release
+	if x == "" && !isNumber(y) {
+		x = "release"
+	}
+	if y == "" && !isNumber(x) {
+		y = "release"
+	}
+	xn, xIsNum := parseNumber(x)
+	yn, yIsNum := parseNumber(y)
+	switch {
+	case xIsNum && yIsNum:
+		return compareInt(xn, yn)
+	case xIsNum:
+		// 数字大于任何限定词
+		return 1
+	case yIsNum:
+		return -1
+	}
+	xo, xKnown := qualifierRank(x)
+	yo, yKnown := qualifierRank(y)
+	if xKnown && yKnown {
+		return compareInt(xo, yo)
+	}
+	if xKnown {
+		return -1
+	}
+	if yKnown {
+		return 1
+	}
+	return strings.Compare(x, y)
+}
+
+func qualifierRank(q string) (int, bool) {
+	rank, ok := qualifierOrder[q]
+	return rank, ok
+}
+
+func parseNumber(s string) (int, bool) {
+	if s == "" {
+		// 缺失项视为 0
+		return 0, true
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
+
+func isReleaseQualifier(q string) bool {
+	rank, ok := qualifierOrder[q]
+	return ok && q != "" && rank == qualifierOrder[""]
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+func compareInt(x, y int) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package utils
+
+import "testing"
+
+func TestCompareVersion(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"2.17.0", "2.17.0", 0},
+		{"2.16.0", "2.17", -1},
+		{"2.17.1", "2.17", 1},
+		{"2.9", "2.10", -1},
+		// 末尾的 0 与 release 类限定词不影响比较
+		{"2.17", "2.17.0", 0},
+		{"1.0", "1.0.0.0", 0},
+		{"1.0-final", "1.0", 0},
+		{"1.0.RELEASE", "1.0.0", 0},
+		{"1.0-GA", "1", 0},
+		// 限定词顺序: alpha < beta < milestone < rc < snapshot < release < sp
+		{"2.0-alpha1", "2.0-beta1", -1},
+		{"2.0-a1", "2.0-alpha1", 0},
+		{"2.0-beta2", "2.0-m1", -1},
+		{"2.0-M1", "2.0-rc1", -1},
+		{"2.0-rc1", "2.0-cr1", 0},
+		{"2.0-rc2", "2.0-SNAPSHOT", -1},
+		{"2.0-SNAPSHOT", "2.0", -1},
+		{"2.0", "2.0-sp1", -1},
+		{"2.17.0-rc1", "2.17.0", -1},
+		{"2.0-rc1", "2.0-RC2", -1},
+		// 数字大于任何限定词，未知限定词大于已知限定词
+		{"1.0.1", "1.0-sp", 1},
+		{"1.0-foo", "1.0-sp", 1},
+		{"1.0-bar", "1.0-foo", -1},
+		{"1.0rc1", "1.0-rc1", 0},
+		{"", "0", 0},
+	}
+	for _, c := range cases {
+		if got := CompareVersion(c.a, c.b); got != c.want {
+			t.Errorf("CompareVersion(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+		if got := CompareVersion(c.b, c.a); got != -c.want {
+			t.Errorf("CompareVersion(%q, %q) = %d, want %d", c.b, c.a, got, -c.want)
+		}
+	}
+}
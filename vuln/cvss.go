@@ -0,0 +1,111 @@
+package vuln
+
+import (
+	"math"
+	"strings"
+)
+
+// 严重等级
+const (
+	SeverityCritical = "CRITICAL"
+	SeverityHigh     = "HIGH"
+	SeverityMedium   = "MEDIUM"
+	SeverityLow      = "LOW"
+	SeverityNone     = "NONE"
+	SeverityUnknown  = "UNKNOWN"
+)
+
+// CVSS v3.x 基础指标权重
+var cvss3Weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// CVSS3BaseScore 根据 CVSS v3.x 向量计算基础分，向量不合法时返回 false
+func CVSS3BaseScore(vector string) (float64, bool) {
+	if !strings.HasPrefix(vector, "CVSS:3.") {
+		return 0, false
+	}
+	metrics := make(map[string]string)
+	for _, part := range strings.Split(vector, "/")[1:] {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) == 2 {
+			metrics[kv[0]] = kv[1]
+		}
+	}
+	scopeChanged := metrics["S"] == "C"
+	value := func(metric string) (float64, bool) {
+		w, ok := cvss3Weights[metric][metrics[metric]]
+		return w, ok
+	}
+	av, ok1 := value("AV")
+	ac, ok2 := value("AC")
+	ui, ok3 := value("UI")
+	c, ok4 := value("C")
+	i, ok5 := value("I")
+	a, ok6 := value("A")
+	if !(ok1 && ok2 && ok3 && ok4 && ok5 && ok6) {
+		return 0, false
+	}
+	var pr float64
+	switch metrics["PR"] {
+	case "N":
+		pr = 0.85
+	case "L":
+		pr = 0.62
+		if scopeChanged {
+			pr = 0.68
+		}
+	case "H":
+		pr = 0.27
+		if scopeChanged {
+			pr = 0.5
+		}
+	default:
+		return 0, false
+	}
+
+	iss := 1 - (1-c)*(1-i)*(1-a)
+	var impact float64
+	if scopeChanged {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	} else {
+		impact = 6.42 * iss
+	}
+	if impact <= 0 {
+		return 0, true
+	}
+	exploitability := 8.22 * av * ac * pr * ui
+	if scopeChanged {
+		return roundUp(math.Min(1.08*(impact+exploitability), 10)), true
+	}
+	return roundUp(math.Min(impact+exploitability, 10)), true
+}
+
+// 向上保留一位小数
+func roundUp(x float64) float64 {
+	n := math.Round(x * 100000)
+	if math.Mod(n, 10000) == 0 {
+		return n / 100000
+	}
+	return (math.Floor(n/10000) + 1) / 10
+}
+
+// SeverityOfScore CVSS 分数对应的严重等级
+func SeverityOfScore(score float64) string {
+	switch {
+	case score >= 9.0:
+		return SeverityCritical
+	case score >= 7.0:
+		return SeverityHigh
+	case score >= 4.0:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	}
+	return SeverityNone
+}
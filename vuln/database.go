@@ -0,0 +1,186 @@
+package vuln
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/zlog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maven 生态
+const ecosystemMaven = "Maven"
+
+// Database 本地漏洞库，按 groupId:artifactId 建立索引，匹配时不需要访问网络
+type Database struct {
+	entries map[string][]*OSV
+	count   int
+}
+
+// DatabaseDir 漏洞库目录，漏洞库文件与模块一样由 daemon 下载
+func DatabaseDir(installDir string) string {
+	return filepath.Join(installDir, "vuln-db")
+}
+
+// LoadDatabase 加载目录下全部的漏洞库文件
+// 支持: 单条记录的 .json、记录数组的 .json 以及 OSV 导出的 .zip (如 Maven/all.zip)
+// 单个文件无法读取或格式错误时跳过，其他文件中的漏洞仍然可以匹配
+func LoadDatabase(dir string) (*Database, error) {
+	db := &Database{entries: make(map[string][]*OSV)}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		switch strings.ToLower(filepath.Ext(file.Name())) {
+		case ".json":
+			data, err := ioutil.ReadFile(path)
+			if err == nil {
+				err = db.addJson(data)
+			}
+			if err != nil {
+				zlog.Warnf(defs.VULN_DB, "skip vuln db file", "file:%s,err:%v", path, err)
+			}
+		case ".zip":
+			if err := db.addZip(path); err != nil {
+				zlog.Warnf(defs.VULN_DB, "skip vuln db file", "file:%s,err:%v", path, err)
+			}
+		}
+	}
+	return db, nil
+}
+
+// Count 漏洞记录数
+func (db *Database) Count() int {
+	return db.count
+}
+
+// addZip 压缩包中单条记录格式错误时跳过该记录
+func (db *Database) addZip(path string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !strings.HasSuffix(strings.ToLower(f.Name), ".json") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+		if err := db.addJson(data); err != nil {
+			zlog.Warnf(defs.VULN_DB, "skip vuln db record", "file:%s,entry:%s,err:%v", path, f.Name, err)
+		}
+	}
+	return nil
+}
+
+func (db *Database) addJson(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil
+	}
+	if data[0] == '[' {
+		var list []*OSV
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		for _, v := range list {
+			db.add(v)
+		}
+		return nil
+	}
+	var v OSV
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	db.add(&v)
+	return nil
+}
+
+func (db *Database) add(v *OSV) {
+	if v.Withdrawn != "" {
+		return // 已撤回的漏洞
+	}
+	indexed := false
+	for _, affected := range v.Affected {
+		if affected.Package.Ecosystem != ecosystemMaven {
+			continue
+		}
+		name := affected.Package.Name
+		list := db.entries[name]
+		if len(list) > 0 && list[len(list)-1] == v {
+			continue // 同一个漏洞对同一个包有多条 affected
+		}
+		db.entries[name] = append(list, v)
+		indexed = true
+	}
+	if indexed {
+		db.count++
+	}
+}
+
+// Loader 漏洞库文件有更新时重新加载
+type Loader struct {
+	dir     string
+	modTime int64
+	db      *Database
+}
+
+func NewLoader(installDir string) *Loader {
+	return &Loader{dir: DatabaseDir(installDir)}
+}
+
+// Database 返回最新的漏洞库，目录下文件有变化时重新加载，没有漏洞库时返回 nil
+func (l *Loader) Database() (*Database, error) {
+	latest, err := latestModTime(l.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if l.db != nil && latest == l.modTime {
+		return l.db, nil
+	}
+	db, err := LoadDatabase(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	l.db = db
+	l.modTime = latest
+	return db, nil
+}
+
+func latestModTime(dir string) (int64, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return 0, err
+	}
+	latest := info.ModTime().UnixNano()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		if t := f.ModTime().UnixNano(); t > latest {
+			latest = t
+		}
+	}
+	return latest, nil
+}
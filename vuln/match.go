@@ -0,0 +1,201 @@
+package vuln
+
+import (
+	"jrasp-daemon/java_process"
+	"jrasp-daemon/utils"
+	"sort"
+	"strings"
+)
+
+// Finding 依赖命中的漏洞
+type Finding struct {
+	Pids     []int32  `json:"pids"` // 应用的全部进程
+	AppId    string   `json:"appId"`
+	Vendor   string   `json:"vendor"`
+	Product  string   `json:"product"`
+	Version  string   `json:"version"`
+	Path     string   `json:"path"`
+	Id       string   `json:"id"`       // OSV/GHSA 编号
+	Cves     []string `json:"cves"`     // CVE 编号
+	Severity string   `json:"severity"` // 严重等级
+	Score    float64  `json:"score"`    // CVSS 分数，未知时为 0
+	Summary  string   `json:"summary"`  // 漏洞描述
+	FixedIn  string   `json:"fixedIn"`  // 修复版本
+}
+
+// Key 同一组件(路径与版本)命中的同一个漏洞
+func (f *Finding) Key() string {
+	return f.Id + "|" + f.Vendor + ":" + f.Product + ":" + f.Version + "|" + f.Path
+}
+
+// Match 匹配依赖列表中存在漏洞的组件，多个进程中相同的依赖只输出一次
+func (db *Database) Match(deps []java_process.Dependency) []Finding {
+	var findings []Finding
+	seen := make(map[string]bool)
+	for _, d := range deps {
+		if d.Vendor == "" || d.Product == "" || d.Version == "" {
+			continue
+		}
+		for _, v := range db.entries[d.Vendor+":"+d.Product] {
+			affected, fixed := v.affects(d.Vendor+":"+d.Product, d.Version)
+			if !affected {
+				continue
+			}
+			severity, score := v.severity()
+			finding := Finding{
+				Vendor:   d.Vendor,
+				Product:  d.Product,
+				Version:  d.Version,
+				Path:     d.Path,
+				Id:       v.Id,
+				Cves:     v.cves(),
+				Severity: severity,
+				Score:    score,
+				Summary:  v.Summary,
+				FixedIn:  fixed,
+			}
+			if !seen[finding.Key()] {
+				seen[finding.Key()] = true
+				findings = append(findings, finding)
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Score > findings[j].Score
+	})
+	return findings
+}
+
+// affects 判断版本是否受影响，返回修复版本
+func (v *OSV) affects(name, version string) (bool, string) {
+	for _, affected := range v.Affected {
+		if affected.Package.Ecosystem != ecosystemMaven || affected.Package.Name != name {
+			continue
+		}
+		for _, av := range affected.Versions {
+			if utils.CompareVersion(av, version) == 0 {
+				return true, fixedVersion(affected.Ranges, version)
+			}
+		}
+		for _, r := range affected.Ranges {
+			if r.Type != "ECOSYSTEM" && r.Type != "SEMVER" {
+				continue
+			}
+			if ok, fixed := inRange(r.Events, version); ok {
+				return true, fixed
+			}
+		}
+	}
+	return false, ""
+}
+
+// inRange 按照 OSV 规范计算版本是否落在 introduced/fixed/last_affected 区间内
+func inRange(events []OSVEvent, version string) (bool, string) {
+	affected := false
+	fixed := ""
+	for _, e := range sortEvents(events) {
+		switch {
+		case e.Introduced != "":
+			if e.Introduced == "0" || utils.CompareVersion(version, e.Introduced) >= 0 {
+				affected = true
+			}
+		case e.Fixed != "":
+			if utils.CompareVersion(version, e.Fixed) >= 0 {
+				affected = false
+			} else if affected && fixed == "" {
+				fixed = e.Fixed
+			}
+		case e.LastAffected != "":
+			if utils.CompareVersion(version, e.LastAffected) > 0 {
+				affected = false
+			}
+		case e.Limit != "":
+			if utils.CompareVersion(version, e.Limit) >= 0 {
+				affected = false
+			}
+		}
+	}
+	if !affected {
+		return false, ""
+	}
+	return true, fixed
+}
+
+// 事件按版本排序，相同版本时 introduced 在前
+func sortEvents(events []OSVEvent) []OSVEvent {
+	sorted := make([]OSVEvent, len(events))
+	copy(sorted, events)
+	eventVersion := func(e OSVEvent) string {
+		switch {
+		case e.Introduced != "":
+			if e.Introduced == "0" {
+				return ""
+			}
+			return e.Introduced
+		case e.Fixed != "":
+			return e.Fixed
+		case e.LastAffected != "":
+			return e.LastAffected
+		}
+		return e.Limit
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		vi, vj := eventVersion(sorted[i]), eventVersion(sorted[j])
+		if vi == "" || vj == "" {
+			return vi == "" && vj != ""
+		}
+		c := utils.CompareVersion(vi, vj)
+		if c == 0 {
+			return sorted[i].Introduced != "" && sorted[j].Introduced == ""
+		}
+		return c < 0
+	})
+	return sorted
+}
+
+func fixedVersion(ranges []OSVRange, version string) string {
+	for _, r := range ranges {
+		if ok, fixed := inRange(r.Events, version); ok {
+			return fixed
+		}
+	}
+	return ""
+}
+
+// severity 优先使用 CVSS v3 向量计算，其次使用 GHSA 的等级
+func (v *OSV) severity() (string, float64) {
+	for _, s := range v.Severity {
+		if s.Type != "CVSS_V3" {
+			continue
+		}
+		if score, ok := CVSS3BaseScore(s.Score); ok {
+			return SeverityOfScore(score), score
+		}
+	}
+	level := strings.ToUpper(v.DatabaseSpecific.Severity)
+	for _, a := range v.Affected {
+		if level == "" {
+			level = strings.ToUpper(a.DatabaseSpecific.Severity)
+		}
+	}
+	switch level {
+	case "MODERATE":
+		return SeverityMedium, 0
+	case SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow:
+		return level, 0
+	}
+	return SeverityUnknown, 0
+}
+
+func (v *OSV) cves() []string {
+	var cves []string
+	if strings.HasPrefix(v.Id, "CVE-") {
+		cves = append(cves, v.Id)
+	}
+	for _, alias := range v.Aliases {
+		if strings.HasPrefix(alias, "CVE-") {
+			cves = append(cves, alias)
+		}
+	}
+	return cves
+}
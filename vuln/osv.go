@@ -0,0 +1,45 @@
+package vuln
+
+// OSV 格式的漏洞记录(https://ossf.github.io/osv-schema/)，GHSA 导出的数据同样使用该格式
+type OSV struct {
+	Id               string           `json:"id"`
+	Aliases          []string         `json:"aliases"`
+	Summary          string           `json:"summary"`
+	Withdrawn        string           `json:"withdrawn"`
+	Severity         []OSVSeverity    `json:"severity"`
+	Affected         []OSVAffected    `json:"affected"`
+	DatabaseSpecific DatabaseSpecific `json:"database_specific"`
+}
+
+type OSVSeverity struct {
+	Type  string `json:"type"`  // CVSS_V3、CVSS_V2
+	Score string `json:"score"` // CVSS 向量
+}
+
+type OSVAffected struct {
+	Package          OSVPackage       `json:"package"`
+	Ranges           []OSVRange       `json:"ranges"`
+	Versions         []string         `json:"versions"`
+	DatabaseSpecific DatabaseSpecific `json:"database_specific"`
+}
+
+type OSVPackage struct {
+	Ecosystem string `json:"ecosystem"` // Maven
+	Name      string `json:"name"`      // groupId:artifactId
+}
+
+type OSVRange struct {
+	Type   string     `json:"type"` // ECOSYSTEM、SEMVER、GIT
+	Events []OSVEvent `json:"events"`
+}
+
+type OSVEvent struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+type DatabaseSpecific struct {
+	Severity string `json:"severity"` // GHSA: CRITICAL、HIGH、MODERATE、LOW
+}
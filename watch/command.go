@@ -0,0 +1,98 @@
+package watch
+
+import (
+	"fmt"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/java_process"
+	"jrasp-daemon/zlog"
+	"sync/atomic"
+)
+
+// 远程命令对单个进程的操作，在注入协程中执行，重复执行结果相同
+
+// AttachPid 注入指定进程，已注入时直接返回；不受运行模式限制，禁用模式除外
+// 同时恢复 detach 命令之前的自动注入
+func (w *Watch) AttachPid(pid int32) error {
+	return w.runTask(func() error {
+		javaProcess, err := w.javaProcess(pid)
+		if err != nil {
+			return err
+		}
+		javaProcess.DetachedByCommand = false
+		if javaProcess.InjectedStatus == java_process.SUCCESS_INJECT {
+			return nil
+		}
+		if javaProcess.IsDisable() {
+			return fmt.Errorf("agent mode of java process %d is disable", pid)
+		}
+		if err := javaProcess.Attach(); err != nil {
+			javaProcess.MarkFailedInjected()
+			atomic.AddUint64(&w.injectFailed, 1)
+			return err
+		}
+		javaProcess.MarkSuccessInjected()
+		atomic.AddUint64(&w.injectSuccess, 1)
+		zlog.Infof(defs.AGENT_SUCCESS_INIT, "java agent init", `{"pid":%d,"status":"%s","startTime":"%s"}`, javaProcess.JavaPid, javaProcess.InjectedStatus, javaProcess.StartTime)
+		return nil
+	})
+}
+
+// DetachPid 卸载指定进程的 agent，未注入时直接返回；之后不再自动注入，直到收到 attach 命令
+func (w *Watch) DetachPid(pid int32) error {
+	return w.runTask(func() error {
+		javaProcess, err := w.javaProcess(pid)
+		if err != nil {
+			return err
+		}
+		javaProcess.DetachedByCommand = true
+		if javaProcess.InjectedStatus != java_process.SUCCESS_INJECT {
+			return nil
+		}
+		if !javaProcess.ExitInjectImmediately() {
+			return fmt.Errorf("shutdown agent of java process %d failed", pid)
+		}
+		return nil
+	})
+}
+
+// FlushPid 刷新指定进程的模块
+func (w *Watch) FlushPid(pid int32) error {
+	return w.runTask(func() error {
+		javaProcess, err := w.javaProcess(pid)
+		if err != nil {
+			return err
+		}
+		if javaProcess.InjectedStatus != java_process.SUCCESS_INJECT {
+			return fmt.Errorf("java process %d is not injected, status: %s", pid, javaProcess.InjectedStatus)
+		}
+		if !javaProcess.SoftFlush() {
+			return fmt.Errorf("flush modules of java process %d failed", pid)
+		}
+		return nil
+	})
+}
+
+// CollectDependencies 立即采集依赖，不等待依赖定时器；与定时采集串行执行
+func (w *Watch) CollectDependencies() {
+	w.logDependencyInfo()
+}
+
+func (w *Watch) javaProcess(pid int32) (*java_process.JavaProcess, error) {
+	if w.checkExisted(pid) {
+		return nil, fmt.Errorf("java process %d not found", pid)
+	}
+	p, ok := w.ProcessSyncMap.Load(pid)
+	if !ok {
+		return nil, fmt.Errorf("java process %d not found", pid)
+	}
+	return p.(*java_process.JavaProcess), nil
+}
+
+// runTask 在注入协程中执行并等待结果
+func (w *Watch) runTask(task func() error) error {
+	done := make(chan error, 1)
+	w.taskChan <- func() {
+		done <- task()
+	}
+	return <-done
+}
@@ -2,12 +2,17 @@ package watch
 
 import (
 	"jrasp-daemon/java_process"
+	"jrasp-daemon/userconfig"
 	"jrasp-daemon/utils"
 )
 
 // HeartBeat 心跳信息
 type HeartBeatInfo struct {
 	Status map[int32]AgentInfo `json:"agentInfo"`
+
+	// 配置来源不可达时使用本地缓存的配置运行
+	ConfigSourceDegraded bool   `json:"configSourceDegraded"`
+	ConfigSourceError    string `json:"configSourceError,omitempty"`
 }
 
 // java agent信息
@@ -15,6 +20,8 @@ type AgentInfo struct {
 	Pid          int32                   `json:"pid"`       // 进程信息
 	StartTime    string                  `json:"startTime"` // 启动时间
 	InjectStatus java_process.InjectType `json:"status"`    // 注入状态
+	AgentMode    userconfig.AgentMode    `json:"agentMode"` // 生效的运行模式
+	Overlay      string                  `json:"overlay"`   // 命中的覆盖配置，为空表示使用全局配置
 	// jdk版本
 }
 
@@ -37,11 +44,24 @@ func (hb *HeartBeatInfo) Append(jp *java_process.JavaProcess) {
 		Pid:          jp.JavaPid,
 		StartTime:    jp.StartTime,
 		InjectStatus: jp.InjectedStatus,
+		AgentMode:    jp.AgentMode,
+		Overlay:      jp.Overlay,
 	}
 	hb.Status[jp.JavaPid] = agentInfo
 }
 
-// 转成json字符串
+// InjectedCount 注入正常的进程数
+func (hb *HeartBeatInfo) InjectedCount() int {
+	count := 0
+	for _, info := range hb.Status {
+		if info.InjectStatus == java_process.SUCCESS_INJECT {
+			count++
+		}
+	}
+	return count
+}
+
+// 转成json字符串，包含进程状态与配置来源状态
 func (hb *HeartBeatInfo) toJsonString() string {
-	return utils.ToString(hb.Status)
+	return utils.ToString(hb)
 }
@@ -0,0 +1,159 @@
+package watch
+
+import (
+	"fmt"
+	"jrasp-daemon/defs"
+	"jrasp-daemon/java_process"
+	"jrasp-daemon/userconfig"
+	"jrasp-daemon/utils"
+	"jrasp-daemon/zlog"
+	"time"
+)
+
+const (
+	maxRolloutAttempts = 5                // 单个进程刷新失败的最大次数，超过后放弃该进程
+	rolloutTimeout     = 30 * time.Minute // 最近一次分配后仍未确认的进程视为失败
+)
+
+// rollout 一次模块发布，全部已注入的JVM确认加载新模块或失败后完成
+type rollout struct {
+	modules  map[string]string // 模块名称与版本
+	removed  []string          // 已删除的模块
+	pids     map[int32]bool    // 需要刷新的进程，true 表示已确认加载
+	attempts map[int32]int     // 刷新失败的次数
+	failed   map[int32]string  // 放弃的进程与原因
+	start    time.Time
+	updated  time.Time // 最近一次分配的时间，超时从此开始计算
+}
+
+// RolloutModules 模块文件变化后通知全部已注入的JVM强制刷新，不阻塞调用方
+// installed 为模块名称与期望的版本，版本为空时只确认已加载；removed 为已删除的模块，确认已卸载
+func (w *Watch) RolloutModules(installed map[string]string, removed []string) {
+	if len(installed) == 0 && len(removed) == 0 {
+		return
+	}
+	w.rolloutMu.Lock()
+	defer w.rolloutMu.Unlock()
+	if w.pendingRollout == nil {
+		w.pendingRollout = make(map[string]string, len(installed))
+	}
+	for name, version := range installed {
+		w.pendingRollout[name] = version
+	}
+	for _, name := range removed {
+		delete(w.pendingRollout, name)
+		if !userconfig.Contains(w.pendingRemoved, name) {
+			w.pendingRemoved = append(w.pendingRemoved, name)
+		}
+	}
+}
+
+// startRollout 在注入协程中把待发布的模块分配给已注入的进程
+func (w *Watch) startRollout() {
+	w.rolloutMu.Lock()
+	modules, removed := w.pendingRollout, w.pendingRemoved
+	w.pendingRollout, w.pendingRemoved = nil, nil
+	w.rolloutMu.Unlock()
+	if len(modules) == 0 && len(removed) == 0 {
+		return
+	}
+	if w.rollout == nil {
+		w.rollout = &rollout{
+			modules:  make(map[string]string),
+			pids:     make(map[int32]bool),
+			attempts: make(map[int32]int),
+			failed:   make(map[int32]string),
+			start:    time.Now(),
+		}
+	}
+	w.rollout.updated = time.Now()
+	for name, version := range modules {
+		w.rollout.modules[name] = version
+	}
+	for _, name := range removed {
+		delete(w.rollout.modules, name)
+		if !userconfig.Contains(w.rollout.removed, name) {
+			w.rollout.removed = append(w.rollout.removed, name)
+		}
+	}
+	w.ProcessSyncMap.Range(func(pid, p interface{}) bool {
+		javaProcess := (p).(*java_process.JavaProcess)
+		if javaProcess.InjectedStatus == java_process.SUCCESS_INJECT {
+			javaProcess.ScheduleModuleFlush(modules, removed)
+			w.rollout.pids[javaProcess.JavaPid] = false
+			delete(w.rollout.attempts, javaProcess.JavaPid)
+			delete(w.rollout.failed, javaProcess.JavaPid)
+		}
+		return true
+	})
+	zlog.Infof(defs.MODULE_ROLLOUT, "module rollout start", `{"modules":%s,"removed":%s,"processes":%d}`, utils.ToString(w.rollout.modules), utils.ToString(w.rollout.removed), len(w.rollout.pids))
+}
+
+func (w *Watch) updateModules(javaProcess *java_process.JavaProcess) {
+	if javaProcess.InjectedStatus != java_process.SUCCESS_INJECT {
+		// 已卸载或注入失败，重新注入时加载磁盘上的新模块
+		javaProcess.NeedUpdateModules = false
+		return
+	}
+	if err := javaProcess.UpdateModules(); err != nil {
+		zlog.Errorf(defs.MODULE_ROLLOUT, "[BUG] flush modules error", "java process[%d],err:%v", javaProcess.JavaPid, err)
+		if w.rollout != nil {
+			if _, ok := w.rollout.pids[javaProcess.JavaPid]; ok {
+				w.rollout.attempts[javaProcess.JavaPid]++
+				if attempts := w.rollout.attempts[javaProcess.JavaPid]; attempts >= maxRolloutAttempts {
+					w.failRollout(javaProcess, fmt.Sprintf("flush failed %d times: %v", attempts, err))
+				}
+			}
+		}
+		return
+	}
+	if w.rollout != nil {
+		if _, ok := w.rollout.pids[javaProcess.JavaPid]; ok {
+			w.rollout.pids[javaProcess.JavaPid] = true
+		}
+	}
+}
+
+// failRollout 放弃刷新该进程，进程继续使用已加载的模块，重新注入或下一次发布时再加载
+func (w *Watch) failRollout(javaProcess *java_process.JavaProcess, reason string) {
+	javaProcess.CancelModuleFlush()
+	w.rollout.failed[javaProcess.JavaPid] = reason
+	zlog.Errorf(defs.MODULE_ROLLOUT, "module rollout failed", "java process[%d],reason:%s", javaProcess.JavaPid, reason)
+}
+
+// checkRollout 全部进程确认加载、失败或已退出、已卸载后标记发布完成
+func (w *Watch) checkRollout() {
+	if w.rollout == nil {
+		return
+	}
+	timeout := time.Since(w.rollout.updated) > rolloutTimeout
+	flushed, skipped, failed := 0, 0, 0
+	for pid, done := range w.rollout.pids {
+		if done {
+			flushed++
+			continue
+		}
+		if _, ok := w.rollout.failed[pid]; ok {
+			failed++
+			continue
+		}
+		p, ok := w.ProcessSyncMap.Load(pid)
+		if ok && p.(*java_process.JavaProcess).NeedUpdateModules {
+			if !timeout {
+				return // 仍有进程未确认
+			}
+			w.failRollout(p.(*java_process.JavaProcess), fmt.Sprintf("not confirmed within %s", rolloutTimeout))
+			failed++
+			continue
+		}
+		skipped++
+	}
+	msg := `{"modules":%s,"removed":%s,"flushed":%d,"skipped":%d,"failed":%d,"elapsed":"%s"}`
+	args := []interface{}{utils.ToString(w.rollout.modules), utils.ToString(w.rollout.removed), flushed, skipped, failed, time.Since(w.rollout.start).Round(time.Second)}
+	if failed > 0 {
+		zlog.Warnf(defs.MODULE_ROLLOUT, "module rollout complete with failures", msg, args...)
+	} else {
+		zlog.Infof(defs.MODULE_ROLLOUT, "module rollout complete", msg, args...)
+	}
+	w.rollout = nil
+}
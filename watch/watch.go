@@ -4,14 +4,21 @@ import (
 	"fmt"
 	"jrasp-daemon/defs"
 	"jrasp-daemon/environ"
+	"jrasp-daemon/inventory"
 	"jrasp-daemon/java_process"
+	"jrasp-daemon/report"
+	"jrasp-daemon/sbom"
+	"jrasp-daemon/source"
 	"jrasp-daemon/userconfig"
 	"jrasp-daemon/utils"
+	"jrasp-daemon/vuln"
 	"jrasp-daemon/zlog"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/shirou/gopsutil/process"
@@ -21,23 +28,47 @@ import (
 type Watch struct {
 	// 环境变量与配置
 	env     *environ.Environ
-	cfg     *userconfig.Config
-	selfPid int32 // jrasp-daemon进程自身pid
-
-	scanTicker             *time.Ticker          // 注入定时器
-	PidExistsTicker        *time.Ticker          // 进程存活检测定时器
-	ProcessInjectTicker    *time.Ticker          // Java进程注入定时器
-	LogReportTicker        *time.Ticker          // 进程信息定时上报
-	DependencyTicker       *time.Ticker          // 依赖信息定时上报
-	HeartBeatReportTicker  *time.Ticker          // 心跳定时器
-	ProcessSyncMap         sync.Map              // 保存监听的java进程
-	JavaProcessHandlerChan chan *process.Process // java 进程处理chan
+	cfg     *userconfig.Current // 当前的配置快照，由 Reloader 发布
+	applied *userconfig.Config  // 注入协程最近一次应用的配置，只在注入协程中访问
+	selfPid int32               // jrasp-daemon进程自身pid
+
+	scanTicker             *time.Ticker            // 注入定时器
+	PidExistsTicker        *time.Ticker            // 进程存活检测定时器
+	ProcessInjectTicker    *time.Ticker            // Java进程注入定时器
+	LogReportTicker        *time.Ticker            // 进程信息定时上报
+	DependencyTicker       *time.Ticker            // 依赖信息定时上报
+	HeartBeatReportTicker  *time.Ticker            // 心跳定时器
+	ProcessSyncMap         sync.Map                // 保存监听的java进程
+	JavaProcessHandlerChan chan *process.Process   // java 进程处理chan
+	configChan             chan *userconfig.Config // 配置热更新chan
+	taskChan               chan func()             // 远程命令等一次性操作，与注入串行执行
+
+	dependencyMu    sync.Mutex       // 依赖定时器与 collect-dependencies 命令串行采集
+	dependencyStore *inventory.Store // 依赖库
+	vulnLoader      *vuln.Loader     // 本地漏洞库
+	sbomGenerator   *sbom.Generator  // sbom 导出
+	digests         *utils.DigestCache
+	vulnFindings    map[string]map[string]bool // 每个应用已经输出过的漏洞，只输出新增的
+
+	injectSuccess uint64 // 累计注入成功次数，用于配置变更后的健康检查
+	injectFailed  uint64 // 累计注入失败次数
+
+	heartBeatHooks []func(hb *HeartBeatInfo) // 每次心跳后回调，用于更新注册中心的实例信息
+	reporter       *report.Client            // 上报到 collector，为 nil 时只写本地日志
+	sourceHealth   source.Health             // 配置来源的连接状态，随心跳上报
+
+	rolloutMu      sync.Mutex
+	pendingRollout map[string]string // 待发布的模块名称与版本，下一次注入定时器触发时处理
+	pendingRemoved []string          // 待卸载的模块
+	rollout        *rollout          // 进行中的模块发布，只在注入协程中访问
 }
 
-func NewWatch(cfg *userconfig.Config, env *environ.Environ) *Watch {
+func NewWatch(current *userconfig.Current, env *environ.Environ) *Watch {
+	cfg := current.Load()
 	w := &Watch{
 		env:                    env,
-		cfg:                    cfg,
+		cfg:                    current,
+		applied:                cfg,
 		selfPid:                int32(os.Getpid()),
 		LogReportTicker:        time.NewTicker(time.Hour * time.Duration(cfg.LogReportTicker)),
 		scanTicker:             time.NewTicker(time.Second * time.Duration(cfg.ScanTicker)),
@@ -46,13 +77,23 @@ func NewWatch(cfg *userconfig.Config, env *environ.Environ) *Watch {
 		HeartBeatReportTicker:  time.NewTicker(time.Minute * time.Duration(cfg.HeartBeatReportTicker)),
 		DependencyTicker:       time.NewTicker(time.Second * time.Duration(cfg.DependencyTicker)),
 		JavaProcessHandlerChan: make(chan *process.Process, 500),
+		configChan:             make(chan *userconfig.Config, 1),
+		taskChan:               make(chan func()),
+		dependencyStore:        inventory.NewStore(env.InstallDir),
+		vulnLoader:             vuln.NewLoader(env.InstallDir),
+		digests:                utils.NewDigestCache(),
+		vulnFindings:           make(map[string]map[string]bool),
+	}
+	w.sbomGenerator = sbom.NewGenerator(env.InstallDir, env.HostName, w.digests)
+	if err := w.dependencyStore.Load(); err != nil {
+		zlog.Warnf(defs.DEPENDENCY_STORE, "load dependency store failed", "err:%v", err)
 	}
 	return w
 }
 
 // JavaProcessFilter 相当于`jps`工具的实现
 func (w *Watch) JavaProcessFilter() {
-	zlog.Infof(defs.WATCH_DEFAULT, "scan java process start...", "scan period:%d(s)", w.cfg.ScanTicker)
+	zlog.Infof(defs.WATCH_DEFAULT, "scan java process start...", "scan period:%d(s)", w.cfg.Load().ScanTicker)
 	for {
 		select {
 		case _, ok := <-w.scanTicker.C:
@@ -81,17 +122,22 @@ func (w *Watch) DoAttach() {
 				zlog.Errorf(defs.WATCH_DEFAULT, "chan shutdown", "java process handler chan closed")
 			}
 			go w.getJavaProcessInfo(p)
+		case newCfg := <-w.configChan:
+			w.applyConfig(newCfg)
+		case task := <-w.taskChan:
+			task()
 		case _, ok := <-w.ProcessInjectTicker.C:
 			if !ok {
 				return
 			}
+			w.startRollout()
 			w.ProcessSyncMap.Range(func(pid, p interface{}) bool {
 				if w.checkExisted(pid) {
 					return true // continue
 				}
 				javaProcess := (p).(*java_process.JavaProcess)
 				if javaProcess.IsInject() {
-					if w.cfg.IsDisable() {
+					if javaProcess.IsDisable() {
 						// 禁用模式,java agent 立即退出
 						javaProcess.ExitInjectImmediately()
 					}
@@ -100,9 +146,21 @@ func (w *Watch) DoAttach() {
 					w.DynamicInject(javaProcess)
 				}
 
+				// 密钥轮换，推送新凭证，失败时下一次定时器触发时重试
+				if javaProcess.NeedUpdateCredential {
+					if javaProcess.InjectedStatus != java_process.SUCCESS_INJECT || javaProcess.UpdateCredential() {
+						javaProcess.NeedUpdateCredential = false
+					}
+				}
+
+				// 模块文件变化，强制刷新并确认加载，失败时下一次定时器触发时重试
+				if javaProcess.NeedUpdateModules {
+					w.updateModules(javaProcess)
+				}
+
 				// 模块参数更新
 				if javaProcess.NeedUpdateParameters {
-					success := javaProcess.UpdateParameters()
+					success := javaProcess.UpdateModuleSet() && javaProcess.UpdateParameters()
 					if !success {
 						zlog.Errorf(defs.WATCH_DEFAULT, "[BUG] update parameters error", "java process[%d]", javaProcess.JavaPid)
 					}
@@ -110,10 +168,57 @@ func (w *Watch) DoAttach() {
 				}
 				return true // continue
 			})
+			w.checkRollout()
 		}
 	}
 }
 
+// Reload 配置热更新，新的快照已由调用方发布，在注入协程中应用定时器与进程的变化，与注入、参数更新串行执行
+func (w *Watch) Reload(newCfg *userconfig.Config) {
+	w.configChan <- newCfg
+}
+
+func (w *Watch) applyConfig(newCfg *userconfig.Config) {
+	old := w.applied
+	w.applied = newCfg
+
+	// 定时器周期
+	if old.ScanTicker != newCfg.ScanTicker {
+		w.scanTicker.Reset(time.Second * time.Duration(newCfg.ScanTicker))
+	}
+	if old.PidExistsTicker != newCfg.PidExistsTicker {
+		w.PidExistsTicker.Reset(time.Second * time.Duration(newCfg.PidExistsTicker))
+	}
+	if old.ProcessInjectTicker != newCfg.ProcessInjectTicker {
+		w.ProcessInjectTicker.Reset(time.Second * time.Duration(newCfg.ProcessInjectTicker))
+	}
+	if old.LogReportTicker != newCfg.LogReportTicker {
+		w.LogReportTicker.Reset(time.Hour * time.Duration(newCfg.LogReportTicker))
+	}
+	if old.HeartBeatReportTicker != newCfg.HeartBeatReportTicker {
+		w.HeartBeatReportTicker.Reset(time.Minute * time.Duration(newCfg.HeartBeatReportTicker))
+	}
+	if old.DependencyTicker != newCfg.DependencyTicker {
+		w.DependencyTicker.Reset(time.Second * time.Duration(newCfg.DependencyTicker))
+	}
+
+	// 运行模式与模块参数按覆盖配置重新计算，下一次注入定时器触发时生效
+	modulesChanged := 0
+	credentialChanged := old.Username != newCfg.Username || old.Password != newCfg.Password
+	w.ProcessSyncMap.Range(func(pid, p interface{}) bool {
+		javaProcess := (p).(*java_process.JavaProcess)
+		if credentialChanged {
+			javaProcess.NeedUpdateCredential = true
+		}
+		if javaProcess.ResolveConfig() {
+			javaProcess.NeedUpdateParameters = true
+			modulesChanged++
+		}
+		return true
+	})
+	zlog.Infof(defs.CONFIG_RELOAD, "config applied", `{"agentMode":"%s","modulesChangedProcesses":%d,"credentialChanged":%t}`, newCfg.AgentMode, modulesChanged, credentialChanged)
+}
+
 func (w *Watch) JavaStatusTimer() {
 	for {
 		select {
@@ -147,12 +252,29 @@ func (w *Watch) logJavaInfo() {
 		} else {
 			processJava := (p).(*java_process.JavaProcess)
 			zlog.Infof(defs.WATCH_DEFAULT, "[LogReport]", utils.ToString(processJava))
+			w.reporter.Send(report.PROCESS, processJava)
 		}
 		return true
 	})
 }
 
 func (w *Watch) logHeartBeat() {
+	hb := w.HeartBeat()
+	if w.sourceHealth != nil {
+		hb.ConfigSourceDegraded, hb.ConfigSourceError = w.sourceHealth.Degraded()
+		if hb.ConfigSourceDegraded {
+			zlog.Warnf(defs.HEART_BEAT, "config source degraded", "use cached config,err:%s", hb.ConfigSourceError)
+		}
+	}
+	zlog.Infof(defs.HEART_BEAT, "[logHeartBeat]", hb.toJsonString())
+	w.reporter.Send(report.HEARTBEAT, hb)
+	for _, hook := range w.heartBeatHooks {
+		hook(hb)
+	}
+}
+
+// HeartBeat 当前监听的进程状态，同时清理已退出的进程
+func (w *Watch) HeartBeat() *HeartBeatInfo {
 	hb := NewHeartBeat()
 	w.ProcessSyncMap.Range(func(pid, p interface{}) bool {
 		exists, err := process.PidExists(pid.(int32))
@@ -167,11 +289,29 @@ func (w *Watch) logHeartBeat() {
 		}
 		return true
 	})
-	zlog.Infof(defs.HEART_BEAT, "[logHeartBeat]", hb.toJsonString())
+	return hb
+}
+
+// SetReporter 设置上报客户端，需要在 JavaStatusTimer 启动前调用
+func (w *Watch) SetReporter(reporter *report.Client) {
+	w.reporter = reporter
+}
+
+// SetSourceHealth 设置配置来源，心跳中上报其连接状态
+func (w *Watch) SetSourceHealth(health source.Health) {
+	w.sourceHealth = health
+}
+
+// OnHeartBeat 注册心跳回调，需要在 JavaStatusTimer 启动前调用
+func (w *Watch) OnHeartBeat(hook func(hb *HeartBeatInfo)) {
+	w.heartBeatHooks = append(w.heartBeatHooks, hook)
 }
 
 func (w *Watch) logDependencyInfo() {
-	var list []java_process.Dependency
+	w.dependencyMu.Lock()
+	defer w.dependencyMu.Unlock()
+	// 同一个应用的多个进程合并后保存
+	apps := make(map[string]*sbom.Jvm)
 	w.ProcessSyncMap.Range(func(pid, p interface{}) bool {
 		exists, err := process.PidExists(pid.(int32))
 		if err != nil || !exists {
@@ -181,16 +321,88 @@ func (w *Watch) logDependencyInfo() {
 			zlog.Infof(defs.JAVA_PROCESS_SHUTDOWN, "[ScanProcess]", "%d", pid)
 		} else {
 			processJava := (p).(*java_process.JavaProcess)
+			var dependencyList []java_process.Dependency
+			success := false
 			if processJava.InjectedStatus == java_process.SUCCESS_INJECT || processJava.InjectedStatus == java_process.SUCCESS_DEGRADE {
-				dependencyList, success := processJava.GetDependency()
-				if success {
-					list = append(list, dependencyList...)
-				}
+				dependencyList, success = processJava.GetDependency()
+			}
+			if !success {
+				// 未注入或者注入失败的进程，从磁盘上的classpath解析
+				dependencyList = processJava.ScanDiskDependency()
 			}
+			w.fillHash(dependencyList)
+			appId := processJava.AppId()
+			app, ok := apps[appId]
+			if !ok {
+				app = &sbom.Jvm{AppId: appId, Jdk: java_process.ReadJdkInfo(java_process.ProcRoot(processJava.JavaPid), processJava.JavaExe)}
+				apps[appId] = app
+			}
+			app.Pids = append(app.Pids, processJava.JavaPid)
+			app.Dependencies = append(app.Dependencies, dependencyList...)
 		}
 		return true
 	})
-	zlog.Infof(defs.DEPENDENCY_INFO, "all java dependency list", "%s", utils.ToString(list))
+	vulnDb, err := w.vulnLoader.Database()
+	if err != nil {
+		zlog.Errorf(defs.VULN_DB, "load vuln db failed", "err:%v", err)
+	}
+	jvms := make([]sbom.Jvm, 0, len(apps))
+	var all []java_process.Dependency
+	for appId, app := range apps {
+		jvms = append(jvms, *app)
+		all = append(all, app.Dependencies...)
+		if vulnDb != nil {
+			w.reportVulnFindings(vulnDb, appId, app.Pids, app.Dependencies)
+		}
+		events, err := w.dependencyStore.Update(appId, app.Pids, app.Dependencies)
+		if err != nil {
+			zlog.Errorf(defs.DEPENDENCY_STORE, "save dependency store failed", "appId:%s,err:%v", appId, err)
+		}
+		for _, event := range events {
+			zlog.Infof(defs.DEPENDENCY_CHANGE, string(event.Type), utils.ToString(event))
+		}
+		w.reporter.Send(report.DEPENDENCY, report.Dependencies{AppId: appId, Pids: app.Pids, Jdk: app.Jdk, Dependencies: app.Dependencies})
+		zlog.Infof(defs.DEPENDENCY_INFO, "java dependency collected", `{"appId":"%s","pids":%s,"count":%d,"changes":%d}`, appId, utils.ToString(app.Pids), len(app.Dependencies), len(events))
+	}
+	// 按jar包去重后的报告
+	depReport := inventory.BuildReport(w.env.HostName, all)
+	if err := inventory.WriteReport(w.env.InstallDir, depReport); err != nil {
+		zlog.Errorf(defs.DEPENDENCY_STORE, "write dependency report failed", "err:%v", err)
+	}
+	zlog.Infof(defs.DEPENDENCY_INFO, "dependency report", `{"records":%d,"artifacts":%d,"file":"%s"}`, depReport.Records, len(depReport.Artifacts), inventory.ReportFile(w.env.InstallDir))
+	sort.Slice(jvms, func(i, j int) bool {
+		return jvms[i].AppId < jvms[j].AppId
+	})
+	if err := w.sbomGenerator.Write(jvms); err != nil {
+		zlog.Errorf(defs.SBOM_EXPORT, "write sbom failed", "err:%v", err)
+	}
+}
+
+// 磁盘上的jar包计算sha256，用于跨进程去重；路径为进程视角，容器内的进程通过 /proc/<pid>/root 访问
+func (w *Watch) fillHash(list []java_process.Dependency) {
+	for i := range list {
+		if list[i].Hash == "" {
+			_, list[i].Hash = w.digests.Get(java_process.ProcRoot(list[i].Pid), list[i].Path)
+		}
+	}
+}
+
+// 离线匹配依赖中的漏洞，按应用输出日志并上报，只输出新增或版本变化后的漏洞
+func (w *Watch) reportVulnFindings(db *vuln.Database, appId string, pids []int32, deps []java_process.Dependency) {
+	logged := w.vulnFindings[appId]
+	current := make(map[string]bool)
+	for _, finding := range db.Match(deps) {
+		key := finding.Key()
+		current[key] = true
+		if logged[key] {
+			continue
+		}
+		finding.Pids = pids
+		finding.AppId = appId
+		zlog.Warnf(defs.VULN_FINDING, "vulnerable dependency found", utils.ToString(finding))
+		w.reporter.Send(report.VULNERABILITY, finding)
+	}
+	w.vulnFindings[appId] = current
 }
 
 // 进程状态、配置等检测
@@ -208,6 +420,13 @@ func (w *Watch) getJavaProcessInfo(procss *process.Process) {
 	// cmdline 信息
 	javaProcess.SetCmdLines()
 
+	// java 可执行文件
+	javaProcess.SetJavaExe()
+
+	// 容器信息与覆盖配置
+	javaProcess.SetContainerInfo()
+	javaProcess.ResolveConfig()
+
 	// 设置java进程启动时间
 	javaProcess.SetStartTime()
 
@@ -249,21 +468,29 @@ func (w *Watch) checkExisted(pid interface{}) bool {
 	return false
 }
 
+// DynamicInject 动态注入模式下注入进程，远程命令卸载的进程跳过
 func (w *Watch) DynamicInject(javaProcess *java_process.JavaProcess) {
-	if w.cfg.IsDynamicMode() {
+	if javaProcess.IsDynamicMode() && !javaProcess.DetachedByCommand {
 		err := javaProcess.Attach()
 		if err != nil {
 			// java_process 执行失败
 			zlog.Errorf(defs.WATCH_DEFAULT, "[BUG] attach to java failed", "taget jvm[%d],err:%v", javaProcess.JavaPid, err)
 			javaProcess.MarkFailedInjected()
+			atomic.AddUint64(&w.injectFailed, 1)
 		} else {
 			// load agent 之后，标记为[注入状态]，防止 agent 错误再次发生，人工介入排查
 			javaProcess.MarkSuccessInjected()
+			atomic.AddUint64(&w.injectSuccess, 1)
 			zlog.Infof(defs.AGENT_SUCCESS_INIT, "java agent init", `{"pid":%d,"status":"%s","startTime":"%s"}`, javaProcess.JavaPid, javaProcess.InjectedStatus, javaProcess.StartTime)
 		}
 	}
 }
 
+// InjectStats 累计的注入成功、失败次数
+func (w *Watch) InjectStats() (success, failed uint64) {
+	return atomic.LoadUint64(&w.injectSuccess), atomic.LoadUint64(&w.injectFailed)
+}
+
 func (w *Watch) checkIsJavaProcess(pids []int32) {
 	for _, pid := range pids {
 		p, err := process.NewProcess(pid)
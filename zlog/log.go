@@ -19,11 +19,11 @@ func InitLogger(logger *Log) {
 }
 
 type Log struct {
-	provider *zap.Logger // 只能输出结构化日志
-	pid      int         // jrasp-daemon pid
-	ip       string      // ip
-	hostName string      // 主机名称
-	level    int         // 日志级别
+	provider *zap.Logger     // 只能输出结构化日志
+	pid      int             // jrasp-daemon pid
+	ip       string          // ip
+	hostName string          // 主机名称
+	level    zap.AtomicLevel // 日志级别，支持运行时修改
 }
 
 // InitLog main 中调用
@@ -53,16 +53,30 @@ func NewLog(logPath, hostName, ip string, logLevel int) *Log {
 	// 获取io.Writer的实现
 	fileWriter := getWriter(logPath)
 
+	level := zap.NewAtomicLevelAt(zapcore.Level(logLevel))
+
 	// 实现多个输出
 	core := zapcore.NewTee(
 		// 日志写入文件
-		zapcore.NewCore(zapcore.NewJSONEncoder(config), zapcore.AddSync(fileWriter), zapcore.Level(logLevel)),
+		zapcore.NewCore(zapcore.NewJSONEncoder(config), zapcore.AddSync(fileWriter), level),
 		// 日志输出到控制台
-		zapcore.NewCore(zapcore.NewJSONEncoder(config), zapcore.NewMultiWriteSyncer(zapcore.AddSync(os.Stdout)), zapcore.Level(logLevel)),
+		zapcore.NewCore(zapcore.NewJSONEncoder(config), zapcore.NewMultiWriteSyncer(zapcore.AddSync(os.Stdout)), level),
 	)
 	logger := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
 
-	return &Log{provider: logger, ip: ip, hostName: hostName, pid: os.Getpid(), level: logLevel}
+	return &Log{provider: logger, ip: ip, hostName: hostName, pid: os.Getpid(), level: level}
+}
+
+// SetLevel 运行时修改日志级别
+func SetLevel(logLevel int) {
+	if defaultLogger == nil {
+		return
+	}
+	defaultLogger.level.SetLevel(zapcore.Level(logLevel))
+}
+
+func enabled(logLevel int) bool {
+	return defaultLogger.level.Enabled(zapcore.Level(logLevel))
 }
 
 func getWriter(filename string) io.Writer {
@@ -89,77 +103,77 @@ func Debugf(logId int, msg string, format string, v ...interface{}) {
 	if defaultLogger == nil {
 		return
 	}
-	if defaultLogger.level <= DebugLevel {
+	if enabled(DebugLevel) {
 		defaultLogger.provider.Debug(
-			msg,
+			Redact(msg),
 			zap.Int("logId", logId),
 			zap.String("ip", defaultLogger.ip),
 			zap.String("hostName", defaultLogger.hostName),
 			zap.Int("pid", defaultLogger.pid),
-			zap.String("detail", fmt.Sprintf(format, v...)))
+			zap.String("detail", Redact(fmt.Sprintf(format, v...))))
 	}
 }
 
 func Infof(logId int, msg string, format string, v ...interface{}) {
 	if defaultLogger == nil {
-		fmt.Printf(format+"\n", v...)
+		fmt.Println(Redact(fmt.Sprintf(format, v...)))
 		return
 	}
-	if defaultLogger.level <= InfoLevel {
+	if enabled(InfoLevel) {
 		defaultLogger.provider.Info(
-			msg,
+			Redact(msg),
 			zap.Int("logId", logId),
 			zap.String("ip", defaultLogger.ip),
 			zap.String("hostName", defaultLogger.hostName),
 			zap.Int("pid", defaultLogger.pid),
-			zap.String("detail", fmt.Sprintf(format, v...)))
+			zap.String("detail", Redact(fmt.Sprintf(format, v...))))
 	}
 }
 
 func Warnf(logId int, msg string, format string, v ...interface{}) {
 	if defaultLogger == nil {
-		fmt.Printf(format+"\n", v...)
+		fmt.Println(Redact(fmt.Sprintf(format, v...)))
 		return
 	}
-	if defaultLogger.level <= WarnLevel {
+	if enabled(WarnLevel) {
 		defaultLogger.provider.Warn(
-			msg,
+			Redact(msg),
 			zap.Int("logId", logId),
 			zap.String("ip", defaultLogger.ip),
 			zap.String("hostName", defaultLogger.hostName),
 			zap.Int("pid", defaultLogger.pid),
-			zap.String("detail", fmt.Sprintf(format, v...)))
+			zap.String("detail", Redact(fmt.Sprintf(format, v...))))
 	}
 }
 
 func Errorf(logId int, msg string, format string, v ...interface{}) {
 	if defaultLogger == nil {
-		fmt.Printf(format+"\n", v...)
+		fmt.Println(Redact(fmt.Sprintf(format, v...)))
 		return
 	}
-	if defaultLogger.level <= ErrorLevel {
+	if enabled(ErrorLevel) {
 		defaultLogger.provider.Error(
-			msg,
+			Redact(msg),
 			zap.Int("logId", logId),
 			zap.String("ip", defaultLogger.ip),
 			zap.String("hostName", defaultLogger.hostName),
 			zap.Int("pid", defaultLogger.pid),
-			zap.String("detail", fmt.Sprintf(format, v...)))
+			zap.String("detail", Redact(fmt.Sprintf(format, v...))))
 	}
 }
 
 func Fatalf(logId int, msg string, format string, v ...interface{}) {
 	if defaultLogger == nil {
-		fmt.Printf(format+"\n", v...)
+		fmt.Println(Redact(fmt.Sprintf(format, v...)))
 		return
 	}
-	if defaultLogger.level <= FatalLevel {
+	if enabled(FatalLevel) {
 		defaultLogger.provider.Fatal(
-			msg,
+			Redact(msg),
 			zap.Int("logId", logId),
 			zap.String("ip", defaultLogger.ip),
 			zap.String("hostName", defaultLogger.hostName),
 			zap.Int("pid", defaultLogger.pid),
-			zap.String("detail", fmt.Sprintf(format, v...)))
+			zap.String("detail", Redact(fmt.Sprintf(format, v...))))
 	}
 }
@@ -0,0 +1,53 @@
+package zlog
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MinSecretLen 密钥的最小长度，过短的密钥替换时会误伤日志中的 pid、端口与版本号
+const MinSecretLen = 8
+
+var redactor = struct {
+	sync.RWMutex
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}{secrets: make(map[string]bool)}
+
+// AddSecret 注册需要脱敏的明文，之后所有日志中出现的明文都替换为 ******
+// 轮换后的旧密钥不移除，避免历史信息再次输出时泄露
+func AddSecret(secret string) {
+	if len(secret) < MinSecretLen {
+		return
+	}
+	redactor.Lock()
+	defer redactor.Unlock()
+	if redactor.secrets[secret] {
+		return
+	}
+	redactor.secrets[secret] = true
+	// 长的密钥优先匹配，包含短密钥的长密钥不会只替换一部分
+	secrets := make([]string, 0, len(redactor.secrets))
+	for s := range redactor.secrets {
+		secrets = append(secrets, s)
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, s := range secrets {
+		pairs = append(pairs, s, "******")
+	}
+	redactor.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact 替换字符串中的明文密钥
+func Redact(s string) string {
+	redactor.RLock()
+	defer redactor.RUnlock()
+	if redactor.replacer == nil {
+		return s
+	}
+	return redactor.replacer.Replace(s)
+}